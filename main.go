@@ -1,11 +1,36 @@
 package main
 
 import (
-	"github.com/hashicorp/terraform-plugin-sdk/plugin"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
 	"github.com/moto-taka/terraform-provider-mysql/mysql"
+	"github.com/moto-taka/terraform-provider-mysql/mysql/port_forward"
 )
 
 func main() {
+	// Terraform handles SIGINT itself and stops the provider over RPC, but a
+	// SIGTERM would otherwise leave SSM sessions and tunnels behind.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("[DEBUG] received %s, closing tunnels", sig)
+		cleanup()
+		os.Exit(1)
+	}()
+
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: mysql.Provider})
+
+	cleanup()
+}
+
+func cleanup() {
+	if err := port_forward.Cleanup(); err != nil {
+		log.Printf("[WARN] failed to close tunnels: %s", err)
+	}
 }
@@ -0,0 +1,133 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dataSourceGrants() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: ReadGrants,
+
+		Schema: map[string]*schema.Schema{
+			"user": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "localhost",
+			},
+
+			"grants": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"privileges": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"object_type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"database": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"table": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"grant_option": {
+							Type:     schema.TypeBool,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func ReadGrants(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	user := d.Get("user").(string)
+	host := normalizeHost(d.Get("host").(string))
+
+	sql := fmt.Sprintf("SHOW GRANTS FOR %s@%s", quoteString(user), quoteString(host))
+	log.Println("[DEBUG] SQL:", sql)
+
+	rows, err := db.QueryContext(ctx, sql)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == nonexistingGrantErrCode {
+			return diag.Errorf("user %s@%s not found", user, host)
+		}
+		return diag.Errorf("error reading grants of %s@%s: %s", user, host, err)
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			return diag.FromErr(err)
+		}
+		lines = append(lines, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return diag.FromErr(err)
+	}
+
+	grants, err := flattenGrants(lines)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(fmt.Sprintf("%s@%s", user, host))
+	d.Set("host", host)
+	if err := d.Set("grants", grants); err != nil {
+		return diag.FromErr(err)
+	}
+	return nil
+}
+
+// flattenGrants turns lines of SHOW GRANTS into the grants attribute. Roles
+// granted to the user and PROXY grants are not on a database object and are
+// left out.
+func flattenGrants(lines []string) ([]interface{}, error) {
+	grants := []interface{}{}
+	for _, grant := range lines {
+		if isProxyGrantOf(grant, "") || !strings.Contains(grant, " ON ") {
+			continue
+		}
+
+		privileges, objectType, database, table, ok := parseGrant(grant)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse grant statement: %s", grant)
+		}
+
+		grants = append(grants, map[string]interface{}{
+			"privileges":   splitPrivileges(privileges),
+			"object_type":  objectType,
+			"database":     database,
+			"table":        table,
+			"grant_option": strings.HasSuffix(grant, " WITH GRANT OPTION"),
+		})
+	}
+	return grants, nil
+}
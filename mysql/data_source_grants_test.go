@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccDataSourceGrants(t *testing.T) {
+	dbName := fmt.Sprintf("tf-test-%d", rand.Intn(100))
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccGrantCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourceGrantsConfig(dbName),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.mysql_grants.test", "id", fmt.Sprintf("jdoe-%s@example.com", dbName)),
+					resource.TestCheckTypeSetElemNestedAttrs("data.mysql_grants.test", "grants.*", map[string]string{
+						"object_type":  "TABLE",
+						"database":     dbName,
+						"table":        "*",
+						"grant_option": "false",
+					}),
+					resource.TestCheckTypeSetElemAttr("data.mysql_grants.test", "grants.*.privileges.*", "SELECT"),
+				),
+			},
+		},
+	})
+}
+
+func TestFlattenGrants(t *testing.T) {
+	grants, err := flattenGrants([]string{
+		"GRANT USAGE ON *.* TO `jdoe`@`%`",
+		"GRANT SELECT, INSERT ON `app`.* TO `jdoe`@`%` WITH GRANT OPTION",
+		"GRANT SELECT (`id`, `name`), UPDATE (`name`) ON `app`.`users` TO `jdoe`@`%`",
+		"GRANT EXECUTE ON PROCEDURE `app`.`report` TO `jdoe`@`%`",
+		"GRANT PROXY ON `app`@`%` TO `jdoe`@`%`",
+		"GRANT `developer`@`%` TO `jdoe`@`%`",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{
+		map[string]interface{}{"privileges": []string{"USAGE"}, "object_type": "TABLE", "database": "*", "table": "*", "grant_option": false},
+		map[string]interface{}{"privileges": []string{"SELECT", "INSERT"}, "object_type": "TABLE", "database": "app", "table": "*", "grant_option": true},
+		map[string]interface{}{"privileges": []string{"SELECT (`id`, `name`)", "UPDATE (`name`)"}, "object_type": "TABLE", "database": "app", "table": "users", "grant_option": false},
+		map[string]interface{}{"privileges": []string{"EXECUTE"}, "object_type": "PROCEDURE", "database": "app", "table": "report", "grant_option": false},
+	}
+	if !reflect.DeepEqual(grants, want) {
+		t.Fatalf("got %v, want %v", grants, want)
+	}
+
+	if _, err := flattenGrants([]string{"GRANT SELECT ON `app TO `jdoe`@`%`"}); err == nil {
+		t.Fatal("expected an unparsable grant to be reported")
+	}
+}
+
+func testAccDataSourceGrantsConfig(dbName string) string {
+	return testAccGrantConfig_basic(dbName) + `
+data "mysql_grants" "test" {
+  user = mysql_grant.test.user
+  host = mysql_grant.test.host
+}
+`
+}
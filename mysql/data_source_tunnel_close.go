@@ -0,0 +1,44 @@
+package mysql
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/moto-taka/terraform-provider-mysql/mysql/port_forward"
+)
+
+// tunnelsClosed is set once mysql_tunnel_close has torn down the tunnels of
+// the process, after which connections through them fail right away instead
+// of being retried until connectToMySQL gives up.
+var tunnelsClosed atomic.Bool
+
+func dataSourceTunnelClose() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: ReadTunnelClose,
+
+		Schema: map[string]*schema.Schema{
+			"closed": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// ReadTunnelClose closes every SSM session and SSH tunnel the provider
+// process has opened, the same way it is done when the process exits.
+func ReadTunnelClose(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	conf := meta.(*MySQLConfiguration)
+
+	tunnelsClosed.Store(true)
+	conf.closePools()
+	if err := port_forward.Cleanup(); err != nil {
+		return diag.Errorf("error closing tunnels: %s", err)
+	}
+
+	d.SetId("tunnel_close")
+	d.Set("closed", conf.Tunneled)
+	return nil
+}
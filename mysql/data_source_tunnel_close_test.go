@@ -0,0 +1,31 @@
+package mysql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestReadTunnelClose(t *testing.T) {
+	t.Cleanup(func() { tunnelsClosed.Store(false) })
+
+	conf := &MySQLConfiguration{
+		Config:   &mysql.Config{Net: "tcp", Addr: "127.0.0.1:3306"},
+		Tunneled: true,
+	}
+	d := schema.TestResourceDataRaw(t, dataSourceTunnelClose().Schema, map[string]interface{}{})
+	if diags := ReadTunnelClose(context.Background(), d, conf); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if !d.Get("closed").(bool) {
+		t.Error("expected closed to be true for a tunneled configuration")
+	}
+
+	_, err := connectToMySQL(context.Background(), conf)
+	if err == nil || !strings.Contains(err.Error(), "mysql_tunnel_close") {
+		t.Fatalf("expected connecting through the closed tunnel to fail right away, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package port_forward
+
+import (
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  []func() error
+)
+
+// registerCleanup records a teardown function (closing a listener, an SSH
+// client, terminating an SSM session, ...) to be run by Cleanup.
+func registerCleanup(f func() error) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	cleanups = append(cleanups, f)
+}
+
+// Cleanup runs every registered teardown function in reverse registration
+// order and forgets them. It is safe to call concurrently and more than once.
+func Cleanup() error {
+	cleanupMu.Lock()
+	fs := cleanups
+	cleanups = nil
+	cleanupMu.Unlock()
+
+	var errors error
+	for i := len(fs) - 1; i >= 0; i-- {
+		if err := fs[i](); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+	}
+
+	return errors
+}
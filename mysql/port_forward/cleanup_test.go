@@ -0,0 +1,63 @@
+package port_forward
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCleanup(t *testing.T) {
+	var order []int
+	registerCleanup(func() error { order = append(order, 1); return nil })
+	registerCleanup(func() error { order = append(order, 2); return fmt.Errorf("boom") })
+	registerCleanup(func() error { order = append(order, 3); return nil })
+
+	err := Cleanup()
+	if err == nil {
+		t.Fatal("expected the cleanup error to be returned")
+	}
+	if fmt.Sprint(order) != "[3 2 1]" {
+		t.Fatalf("cleanups ran in unexpected order: %v", order)
+	}
+
+	if err := Cleanup(); err != nil {
+		t.Fatalf("second Cleanup should be a no-op, got %s", err)
+	}
+	if len(order) != 3 {
+		t.Fatalf("cleanups ran more than once: %v", order)
+	}
+}
+
+func TestCleanup_concurrent(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registerCleanup(func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				count++
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	var cwg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			Cleanup()
+		}()
+	}
+	cwg.Wait()
+
+	if count != 50 {
+		t.Fatalf("expected 50 cleanups to run exactly once, got %d", count)
+	}
+}
@@ -0,0 +1,41 @@
+package port_forward
+
+import "errors"
+
+// Errors identifying the phase in which setting up a tunnel failed. They are
+// matched with errors.Is; the message of the returned error is the one of the
+// underlying failure.
+var (
+	// ErrSSMStartSession is reported when AWS refuses to start the SSM
+	// session.
+	ErrSSMStartSession = errors.New("starting the SSM session failed")
+	// ErrSSHConnect is reported when the SSH host can't be reached.
+	ErrSSHConnect = errors.New("connecting to the SSH host failed")
+	// ErrSSHHandshake is reported when the SSH host is reached but the
+	// handshake fails, e.g. because of the host key or authentication.
+	ErrSSHHandshake = errors.New("the SSH handshake failed")
+	// ErrPortBind is reported when a local port of the tunnel can't be
+	// listened on.
+	ErrPortBind = errors.New("listening on the local port failed")
+	// ErrReadinessTimeout is reported when the forwarded port doesn't accept
+	// connections in time after the tunnel was opened.
+	ErrReadinessTimeout = errors.New("the forwarded port did not become ready")
+)
+
+// phaseError ties err to the setup phase it happened in.
+type phaseError struct {
+	phase error
+	err   error
+}
+
+func withPhase(phase error, err error) error {
+	return &phaseError{phase: phase, err: err}
+}
+
+func (e *phaseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *phaseError) Unwrap() []error {
+	return []error{e.phase, e.err}
+}
@@ -0,0 +1,43 @@
+package port_forward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPhaseError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := withPhase(ErrSSHConnect, cause)
+
+	if !errors.Is(err, ErrSSHConnect) {
+		t.Error("expected the error to match its phase")
+	}
+	if !errors.Is(err, cause) {
+		t.Error("expected the error to match its cause")
+	}
+	if errors.Is(err, ErrSSHHandshake) {
+		t.Error("expected the error not to match another phase")
+	}
+	if err.Error() != cause.Error() {
+		t.Errorf("expected the message of the cause, got %q", err)
+	}
+}
+
+func TestListen_portBind(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pfConf := &portFowardConfig{localBindAddress: "127.0.0.1"}
+	_, err = pfConf.listen(ctx, uint16(l.Addr().(*net.TCPAddr).Port), "db.example.com:3306")
+	if !errors.Is(err, ErrPortBind) {
+		t.Fatalf("expected ErrPortBind, got %v", err)
+	}
+}
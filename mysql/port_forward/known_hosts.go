@@ -0,0 +1,115 @@
+package port_forward
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Where host keys accepted on first use are recorded.
+const (
+	// knownHostsScopeUser appends them to ~/.ssh/known_hosts.
+	knownHostsScopeUser = "user"
+	// knownHostsScopeTunnel appends them to a known_hosts file in the
+	// Terraform data directory, see tunnelKnownHostsPath.
+	knownHostsScopeTunnel = "tunnel"
+	// knownHostsScopeMemory keeps them for the lifetime of the provider
+	// process only.
+	knownHostsScopeMemory = "memory"
+)
+
+// tunnelKnownHostsPath returns the known_hosts file of the tunnel scope, next
+// to the working directory's providers and modules.
+func tunnelKnownHostsPath() string {
+	dir := os.Getenv("TF_DATA_DIR")
+	if dir == "" {
+		dir = ".terraform"
+	}
+	return filepath.Join(dir, "terraform-provider-mysql", "known_hosts")
+}
+
+// acceptedHostKeys holds the host keys accepted with the memory scope, by
+// normalized address.
+var acceptedHostKeys sync.Map
+
+// tunnelHostKeyCallback verifies host keys against userFile and the tunnel's
+// own known_hosts file, and records unknown hosts in the latter only.
+func tunnelHostKeyCallback(userFile string) (ssh.HostKeyCallback, error) {
+	scoped := tunnelKnownHostsPath()
+	return tofuHostKeyCallback(existingFiles(userFile, scoped), func(addr string, key ssh.PublicKey) error {
+		if err := os.MkdirAll(filepath.Dir(scoped), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(scoped, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{addr}, key))
+		return err
+	})
+}
+
+// memoryHostKeyCallback verifies host keys against userFile and the ones
+// accepted earlier in this process, and records unknown hosts in memory only.
+func memoryHostKeyCallback(userFile string) (ssh.HostKeyCallback, error) {
+	return tofuHostKeyCallback(existingFiles(userFile), func(addr string, key ssh.PublicKey) error {
+		addr = knownhosts.Normalize(addr)
+		accepted, _ := acceptedHostKeys.LoadOrStore(addr, key)
+		if !bytes.Equal(accepted.(ssh.PublicKey).Marshal(), key.Marshal()) {
+			return fmt.Errorf("ssh: host key %s of %s does not match the key %s accepted earlier", ssh.FingerprintSHA256(key), addr, ssh.FingerprintSHA256(accepted.(ssh.PublicKey)))
+		}
+		return nil
+	})
+}
+
+// tofuHostKeyCallback verifies host keys against files and hands those of
+// hosts not found in any of them to accept, under the host name they are
+// looked up by. A host listed with a different key is rejected.
+func tofuHostKeyCallback(files []string, accept func(addr string, key ssh.PublicKey) error) (ssh.HostKeyCallback, error) {
+	var cb ssh.HostKeyCallback
+	if len(files) > 0 {
+		var err error
+		cb, err = knownhosts.New(files...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if remote.String() == "pipe" {
+			remote = &addrImpl{
+				network: remote.Network(),
+				addr:    hostname,
+			}
+		}
+
+		if cb != nil {
+			err := cb(hostname, remote, key)
+			var ke *knownhosts.KeyError
+			if !errors.As(err, &ke) || len(ke.Want) > 0 {
+				return err
+			}
+		}
+
+		return accept(hostname, key)
+	}, nil
+}
+
+func existingFiles(paths ...string) []string {
+	var existing []string
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			existing = append(existing, p)
+		}
+	}
+	return existing
+}
@@ -0,0 +1,142 @@
+package port_forward
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeUserKnownHosts creates ~/.ssh/known_hosts in a new home directory,
+// listing key for addr, and returns its path.
+func writeUserKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	p := filepath.Join(home, ".ssh", "known_hosts")
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(knownhosts.Line([]string{addr}, key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCreateHostKeyCallback_tunnelScope(t *testing.T) {
+	known, unknown := newHostKey(t), newHostKey(t)
+	userFile := writeUserKnownHosts(t, "known.example.com:22", known)
+	userBefore, err := os.ReadFile(userFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TF_DATA_DIR", t.TempDir())
+
+	conf := &portFowardConfig{knownHostsScope: knownHostsScopeTunnel}
+	cb, err := conf.createHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knownAddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	if err := cb("known.example.com:22", knownAddr, known); err != nil {
+		t.Fatalf("expected the key in ~/.ssh/known_hosts to be accepted: %s", err)
+	}
+	if err := cb("known.example.com:22", knownAddr, unknown); err == nil {
+		t.Fatal("expected a changed key of a host in ~/.ssh/known_hosts to be rejected")
+	}
+
+	newAddr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 22}
+	if err := cb("new.example.com:22", newAddr, unknown); err != nil {
+		t.Fatalf("expected an unknown host to be accepted: %s", err)
+	}
+
+	scoped, err := os.ReadFile(tunnelKnownHostsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(scoped), knownhosts.Normalize("new.example.com:22")) {
+		t.Fatalf("expected the new host to be recorded, got %q", scoped)
+	}
+	userAfter, err := os.ReadFile(userFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(userAfter) != string(userBefore) {
+		t.Fatal("expected ~/.ssh/known_hosts to be left alone")
+	}
+
+	// A later run picks up the recorded key.
+	cb, err = conf.createHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cb("new.example.com:22", newAddr, known); err == nil {
+		t.Fatal("expected a changed key of a recorded host to be rejected")
+	}
+}
+
+func TestCreateHostKeyCallback_memoryScope(t *testing.T) {
+	// Without ~/.ssh/known_hosts, which the user scope requires.
+	t.Setenv("HOME", t.TempDir())
+
+	first, second := newHostKey(t), newHostKey(t)
+	conf := &portFowardConfig{knownHostsScope: knownHostsScopeMemory}
+	cb, err := conf.createHostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 2222}
+	if err := cb("bastion.example.com:2222", remote, first); err != nil {
+		t.Fatalf("expected an unknown host to be accepted: %s", err)
+	}
+	if err := cb("bastion.example.com:2222", remote, first); err != nil {
+		t.Fatalf("expected the accepted key to be accepted again: %s", err)
+	}
+	if err := cb("bastion.example.com:2222", remote, second); err == nil {
+		t.Fatal("expected a changed key to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("HOME"), ".ssh")); !os.IsNotExist(err) {
+		t.Fatal("expected nothing to be written to ~/.ssh")
+	}
+}
+
+func TestParsePFConfig_knownHostsScope(t *testing.T) {
+	conf := map[string]string{
+		"remote_endpoint":   "bastion.example.com:22",
+		"db_endpoint":       "db.example.com:3306",
+		"ssh_user":          "ec2-user",
+		"ssh_private_key":   "unused",
+		"known_hosts_scope": "tunnel",
+	}
+	pfConf, err := ParsePFConfig(conf, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pfConf.knownHostsScope != knownHostsScopeTunnel {
+		t.Fatalf("expected the tunnel scope, got %q", pfConf.knownHostsScope)
+	}
+
+	conf["known_hosts_scope"] = "global"
+	if _, err := ParsePFConfig(conf, 3306); err == nil {
+		t.Fatal("expected an unknown scope to be rejected")
+	}
+}
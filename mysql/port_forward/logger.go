@@ -0,0 +1,80 @@
+package port_forward
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// logLevel orders the tunnel's log messages by importance. Messages are
+// written with the std logger, prefixed with their level for Terraform's log
+// filtering, if they are at least as important as the configured level.
+type logLevel int32
+
+const (
+	logOff logLevel = iota
+	logError
+	logWarn
+	logInfo
+	logDebug
+)
+
+var logLevelNames = map[string]logLevel{
+	"off":   logOff,
+	"error": logError,
+	"warn":  logWarn,
+	"info":  logInfo,
+	"debug": logDebug,
+}
+
+var logPrefixes = map[logLevel]string{
+	logError: "[ERROR] ",
+	logWarn:  "[WARN] ",
+	logInfo:  "[INFO] ",
+	logDebug: "[DEBUG] ",
+}
+
+var currentLogLevel atomic.Int32
+
+func init() {
+	currentLogLevel.Store(int32(logWarn))
+}
+
+// SetLogLevel sets the level of the messages the tunnel logs: off, error,
+// warn, info or debug. Routine failures of single connections, such as a
+// client going away, are only logged at info and debug.
+func SetLogLevel(name string) error {
+	level, ok := logLevelNames[name]
+	if !ok {
+		return fmt.Errorf("tunnel_log_level: unknown level %q", name)
+	}
+	currentLogLevel.Store(int32(level))
+	return nil
+}
+
+// DefaultLogLevel returns the level to use when none is configured: the one
+// of the provider's log, set with TF_LOG_PROVIDER or TF_LOG, so that
+// TF_LOG=DEBUG shows the tunnel's debug messages too. Without either it is
+// warn.
+func DefaultLogLevel() string {
+	for _, env := range []string{"TF_LOG_PROVIDER", "TF_LOG"} {
+		switch strings.ToLower(os.Getenv(env)) {
+		case "trace", "json", "debug":
+			return "debug"
+		case "info", "warn", "error":
+			return strings.ToLower(os.Getenv(env))
+		case "off":
+			return "off"
+		}
+	}
+	return "warn"
+}
+
+func logf(level logLevel, format string, v ...interface{}) {
+	if level == logOff || level > logLevel(currentLogLevel.Load()) {
+		return
+	}
+	log.Printf(logPrefixes[level]+format, v...)
+}
@@ -0,0 +1,71 @@
+package port_forward
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLogLevel("warn")
+
+	cases := []struct {
+		level string
+		want  []string
+	}{
+		{"off", nil},
+		{"error", []string{"[ERROR] e"}},
+		{"warn", []string{"[ERROR] e", "[WARN] w"}},
+		{"debug", []string{"[ERROR] e", "[WARN] w", "[INFO] i", "[DEBUG] d"}},
+	}
+	for _, c := range cases {
+		if err := SetLogLevel(c.level); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		logf(logError, "e")
+		logf(logWarn, "w")
+		logf(logInfo, "i")
+		logf(logDebug, "d")
+
+		for _, want := range c.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s: expected %q to be logged, got %q", c.level, want, buf.String())
+			}
+		}
+		if got := strings.Count(buf.String(), "\n"); got != len(c.want) {
+			t.Errorf("%s: expected %d messages, got %q", c.level, len(c.want), buf.String())
+		}
+	}
+
+	if err := SetLogLevel("verbose"); err == nil {
+		t.Fatal("expected an unknown level to be rejected")
+	}
+}
+
+func TestDefaultLogLevel(t *testing.T) {
+	cases := []struct {
+		provider string
+		tfLog    string
+		want     string
+	}{
+		{"", "", "warn"},
+		{"", "DEBUG", "debug"},
+		{"", "TRACE", "debug"},
+		{"", "info", "info"},
+		{"ERROR", "DEBUG", "error"},
+		{"", "bogus", "warn"},
+	}
+	for _, c := range cases {
+		t.Setenv("TF_LOG_PROVIDER", c.provider)
+		t.Setenv("TF_LOG", c.tfLog)
+		if got := DefaultLogLevel(); got != c.want {
+			t.Errorf("TF_LOG_PROVIDER=%q TF_LOG=%q: got %s, want %s", c.provider, c.tfLog, got, c.want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package port_forward
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,20 +13,82 @@ import (
 	"os/exec"
 	"os/user"
 	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+const (
+	mysqlProtocolVersion = 0x0a
+	mysqlErrPacket       = 0xff
+)
+
+// keepAlivePeriod is the TCP keepalive interval used for tunnel connections so
+// that half-open connections through a flaky bastion are detected.
+const keepAlivePeriod = 30 * time.Second
+
+const (
+	defaultConnectAttempts      = 1
+	defaultConnectRetryInterval = 2 * time.Second
+	defaultInnerDialTimeout     = 30 * time.Second
+	defaultLocalBindAddress     = "127.0.0.1"
+)
+
+// startupErrorWindow is how long Connect waits for the tunnel to fail right
+// after it has been set up, e.g. because the listener stops accepting or the
+// session-manager-plugin exits.
+const startupErrorWindow = 2 * time.Second
+
 type portFowardConfig struct {
 	sshUser              string
-	keyPath              string
+	keyPaths             []string
+	privateKey           string
+	sshAgent             bool
+	sshPassword          string
 	localPort            uint16
+	localBindAddress     string
+	socksPort            uint16
 	remoteEndpoint       string
 	dbEndpoint           string
 	useRemotePortForward bool
+	verifyHandshake      bool
+	connectAttempts      int
+	connectRetryInterval time.Duration
+	disableKnownHosts    bool
+	knownHostsScope      string
+	hostKeys             []ssh.PublicKey
+	innerDialTimeout     time.Duration
+	// nextHop is a second SSH server, reached through the first one, that
+	// db_endpoint is dialed from instead.
+	nextHop *portFowardConfig
+	// additionalForwards are further local ports forwarded through the same
+	// SSH connection, each to its own DB endpoint.
+	additionalForwards []additionalForward
+	// proxyProtocol is the PROXY protocol version whose header is sent
+	// ahead of every forwarded connection, empty for none.
+	proxyProtocol string
+	autoReconnect bool
+	// keepOpen leaves the tunnel running after the provider exits, see
+	// KeepOpen.
+	keepOpen bool
+	// client is the SSH client connections are forwarded through. It is
+	// replaced when auto_reconnect re-establishes a dropped connection.
+	client atomic.Pointer[ssh.Client]
+	// errs receives failures from the goroutines serving the tunnel.
+	errs chan error
+}
+
+type additionalForward struct {
+	localPort  uint16
+	dbEndpoint string
 }
 
 func ParsePFConfigMap(d *schema.ResourceData) (map[string]string, error) {
@@ -47,6 +112,43 @@ func ParsePFConfigMap(d *schema.ResourceData) (map[string]string, error) {
 	if v, ok := confMap["db_endpoint"].(string); ok && v != "" {
 		pfConf["db_endpoint"] = v
 	}
+	setDBHostPort(pfConf, confMap)
+
+	if v, ok := confMap["verify_handshake"].(bool); ok {
+		pfConf["verify_handshake"] = strconv.FormatBool(v)
+	}
+
+	if v, ok := confMap["local_port"].(int); ok && v > 0 {
+		pfConf["local_port"] = strconv.Itoa(v)
+	}
+
+	if v, ok := confMap["local_bind_address"].(string); ok && v != "" {
+		pfConf["local_bind_address"] = v
+	}
+
+	if v, ok := confMap["socks_local_port"].(int); ok && v > 0 {
+		pfConf["socks_local_port"] = strconv.Itoa(v)
+	}
+
+	if v, ok := confMap["connect_attempts"].(int); ok && v > 0 {
+		pfConf["connect_attempts"] = strconv.Itoa(v)
+	}
+
+	if v, ok := confMap["connect_retry_interval_sec"].(int); ok && v > 0 {
+		pfConf["connect_retry_interval_sec"] = strconv.Itoa(v)
+	}
+
+	if v, ok := confMap["inner_dial_timeout_sec"].(int); ok && v > 0 {
+		pfConf["inner_dial_timeout_sec"] = strconv.Itoa(v)
+	}
+
+	if v, ok := confMap["auto_reconnect"].(bool); ok {
+		pfConf["auto_reconnect"] = strconv.FormatBool(v)
+	}
+
+	if v, ok := confMap["send_proxy_protocol"].(string); ok && v != "" {
+		pfConf["send_proxy_protocol"] = v
+	}
 
 	cu, _ := user.Current()
 	pfConf["ssh_user"] = cu.Username
@@ -54,9 +156,23 @@ func ParsePFConfigMap(d *schema.ResourceData) (map[string]string, error) {
 		pfConf["ssh_user"] = v
 	}
 
-	pfConf["ssh_key_path"] = defaultSSHKeyPath()
-	if v, ok := confMap["ssh_key_path"].(string); ok && v != "" {
-		pfConf["ssh_key_path"] = v
+	if v, ok := confMap["ssh_private_key"].(string); ok && v != "" {
+		pfConf["ssh_private_key"] = v
+	} else {
+		setSSHKeyPaths(pfConf, confMap)
+	}
+	setSSHAuth(pfConf, confMap)
+
+	if v, ok := confMap["disable_known_hosts"].(bool); ok {
+		pfConf["disable_known_hosts"] = strconv.FormatBool(v)
+	}
+
+	if v, ok := confMap["known_hosts_scope"].(string); ok && v != "" {
+		pfConf["known_hosts_scope"] = v
+	}
+
+	if v, ok := confMap["ssh_host_public_key"].(string); ok && v != "" {
+		pfConf["ssh_host_public_key"] = v
 	}
 
 	return pfConf, nil
@@ -71,19 +187,104 @@ func ParsePFConfig(confMap map[string]string, localPort uint16) (*portFowardConf
 	if !(len(confMap) > 0) {
 		return nil, fmt.Errorf("parseSSHConfig's format validate")
 	}
-	conf := &portFowardConfig{}
+	conf := &portFowardConfig{
+		connectAttempts:      defaultConnectAttempts,
+		connectRetryInterval: defaultConnectRetryInterval,
+		innerDialTimeout:     defaultInnerDialTimeout,
+		localBindAddress:     defaultLocalBindAddress,
+		errs:                 make(chan error, 1),
+	}
 	conf.localPort = localPort
 
+	if v, ok := confMap["local_port"]; ok && v != "" {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("local_port: %s", err)
+		}
+		conf.localPort = uint16(port)
+	}
+
+	if v, ok := confMap["local_bind_address"]; ok && v != "" {
+		if net.ParseIP(v) == nil {
+			return nil, fmt.Errorf("local_bind_address: %q is not an IP address", v)
+		}
+		conf.localBindAddress = v
+	}
+
 	if v, ok := confMap["remote_endpoint"]; ok && v != "" {
 		conf.remoteEndpoint = v
 	}
 
 	if v, ok := confMap["db_endpoint"]; ok && v != "" {
-		conf.dbEndpoint = v
+		conf.dbEndpoint = dbEndpointAddr(v)
 	}
 
 	if v, ok := confMap["use_remote_port_forward"]; ok && v != "" {
-		conf.useRemotePortForward = true
+		conf.useRemotePortForward, _ = strconv.ParseBool(v)
+	}
+
+	if v, ok := confMap["socks_local_port"]; ok && v != "" {
+		if conf.useRemotePortForward {
+			return nil, fmt.Errorf("socks_local_port requires use_remote_port_forward = false, as the proxy is served over SSH")
+		}
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("socks_local_port: %s", err)
+		}
+		conf.socksPort = uint16(port)
+	}
+
+	if v, ok := confMap["verify_handshake"]; ok && v != "" {
+		conf.verifyHandshake, _ = strconv.ParseBool(v)
+	}
+
+	if v, ok := confMap["connect_attempts"]; ok && v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts < 1 {
+			return nil, fmt.Errorf("connect_attempts: %q is not a positive integer", v)
+		}
+		conf.connectAttempts = attempts
+	}
+
+	if v, ok := confMap["connect_retry_interval_sec"]; ok && v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 {
+			return nil, fmt.Errorf("connect_retry_interval_sec: %q is not a positive integer", v)
+		}
+		conf.connectRetryInterval = time.Duration(sec) * time.Second
+	}
+
+	if v, ok := confMap["inner_dial_timeout_sec"]; ok && v != "" {
+		sec, err := strconv.Atoi(v)
+		if err != nil || sec < 1 {
+			return nil, fmt.Errorf("inner_dial_timeout_sec: %q is not a positive integer", v)
+		}
+		conf.innerDialTimeout = time.Duration(sec) * time.Second
+	}
+
+	if v, ok := confMap["auto_reconnect"]; ok && v != "" {
+		conf.autoReconnect, _ = strconv.ParseBool(v)
+	}
+
+	if v, ok := confMap["additional_forwards"]; ok && v != "" {
+		if conf.useRemotePortForward {
+			return nil, fmt.Errorf("additional_forward requires use_remote_port_forward = false, as a remote port forwarding session reaches a single host")
+		}
+		forwards, err := parseAdditionalForwards(v)
+		if err != nil {
+			return nil, err
+		}
+		conf.additionalForwards = forwards
+	}
+
+	if v, ok := confMap["send_proxy_protocol"]; ok && v != "" {
+		if conf.useRemotePortForward {
+			return nil, fmt.Errorf("send_proxy_protocol requires use_remote_port_forward = false, as session-manager-plugin forwards the connections itself")
+		}
+		if v != proxyProtocolV1 && v != proxyProtocolV2 {
+			return nil, fmt.Errorf("send_proxy_protocol: unsupported version %q, expected %s or %s", v, proxyProtocolV1, proxyProtocolV2)
+		}
+		conf.proxyProtocol = v
 	}
 
 	if conf.useRemotePortForward {
@@ -96,9 +297,53 @@ func ParsePFConfig(confMap map[string]string, localPort uint16) (*portFowardConf
 		conf.sshUser = v
 	}
 
-	conf.keyPath = defaultSSHKeyPath()
-	if v, ok := confMap["ssh_key_path"]; ok && v != "" {
-		conf.keyPath = v
+	if v, ok := confMap["ssh_private_key"]; ok && v != "" {
+		conf.privateKey = v
+	} else {
+		if v, ok := confMap["ssh_key_path"]; ok && v != "" {
+			conf.keyPaths = append(conf.keyPaths, v)
+		}
+		if v, ok := confMap["ssh_key_paths"]; ok && v != "" {
+			conf.keyPaths = append(conf.keyPaths, strings.Split(v, "\n")...)
+		}
+		if len(conf.keyPaths) == 0 {
+			conf.keyPaths = []string{defaultSSHKeyPath()}
+		}
+	}
+
+	if v, ok := confMap["ssh_agent"]; ok && v != "" {
+		conf.sshAgent, _ = strconv.ParseBool(v)
+	}
+
+	if v, ok := confMap["ssh_password"]; ok && v != "" {
+		conf.sshPassword = v
+	}
+
+	if v, ok := confMap["disable_known_hosts"]; ok && v != "" {
+		conf.disableKnownHosts, _ = strconv.ParseBool(v)
+	}
+
+	switch v := confMap["known_hosts_scope"]; v {
+	case "", knownHostsScopeUser, knownHostsScopeTunnel, knownHostsScopeMemory:
+		conf.knownHostsScope = v
+	default:
+		return nil, fmt.Errorf("known_hosts_scope: unsupported scope %q, expected %s, %s or %s", v, knownHostsScopeUser, knownHostsScopeTunnel, knownHostsScopeMemory)
+	}
+
+	if v, ok := confMap["ssh_host_public_key"]; ok && v != "" {
+		keys, err := parseHostKeys(v)
+		if err != nil {
+			return nil, fmt.Errorf("ssh_host_public_key: %w", err)
+		}
+		conf.hostKeys = keys
+	}
+
+	if v, ok := confMap["next_hop_host"]; ok && v != "" {
+		hop, err := parseNextHop(confMap, conf)
+		if err != nil {
+			return nil, err
+		}
+		conf.nextHop = hop
 	}
 
 	if err := conf.validate(); err != nil {
@@ -108,6 +353,93 @@ func ParsePFConfig(confMap map[string]string, localPort uint16) (*portFowardConf
 	return conf, nil
 }
 
+// parseAdditionalForwards reads the lines written by setAdditionalForwards.
+func parseAdditionalForwards(v string) ([]additionalForward, error) {
+	var forwards []additionalForward
+	for _, line := range strings.Split(v, "\n") {
+		port, endpoint, _ := strings.Cut(line, " ")
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("additional_forward.local_port: %s", err)
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("additional_forward: db_endpoint is required")
+		}
+		forwards = append(forwards, additionalForward{localPort: uint16(p), dbEndpoint: dbEndpointAddr(endpoint)})
+	}
+	return forwards, nil
+}
+
+// parseNextHop reads the next_hop_* settings. The user, key and host key
+// verification of the first hop apply unless they are set for the next one.
+func parseNextHop(confMap map[string]string, first *portFowardConfig) (*portFowardConfig, error) {
+	hop := &portFowardConfig{
+		remoteEndpoint:    confMap["next_hop_host"],
+		sshUser:           first.sshUser,
+		privateKey:        first.privateKey,
+		keyPaths:          first.keyPaths,
+		sshAgent:          first.sshAgent,
+		disableKnownHosts: first.disableKnownHosts,
+		knownHostsScope:   first.knownHostsScope,
+		hostKeys:          first.hostKeys,
+	}
+	if _, _, err := net.SplitHostPort(hop.remoteEndpoint); err != nil {
+		hop.remoteEndpoint = net.JoinHostPort(hop.remoteEndpoint, "22")
+	}
+
+	if v, ok := confMap["next_hop_ssh_user"]; ok && v != "" {
+		hop.sshUser = v
+	}
+
+	if v, ok := confMap["next_hop_ssh_private_key"]; ok && v != "" {
+		hop.privateKey = v
+		hop.keyPaths = nil
+	} else if v, ok := confMap["next_hop_ssh_key_path"]; ok && v != "" {
+		hop.privateKey = ""
+		hop.keyPaths = []string{v}
+	}
+
+	if v, ok := confMap["next_hop_ssh_host_public_key"]; ok && v != "" {
+		keys, err := parseHostKeys(v)
+		if err != nil {
+			return nil, fmt.Errorf("ssh_next_hop.ssh_host_public_key: %w", err)
+		}
+		hop.hostKeys = keys
+	}
+
+	if err := hop.validate(); err != nil {
+		return nil, fmt.Errorf("ssh_next_hop: %w", err)
+	}
+	return hop, nil
+}
+
+// dialNextHop opens an SSH connection to the next hop through client, or
+// returns client itself if there is none.
+func (pfConf *portFowardConfig) dialNextHop(ctx context.Context, client *ssh.Client) (*ssh.Client, error) {
+	if pfConf.nextHop == nil {
+		return client, nil
+	}
+
+	sshConf, err := pfConf.nextHop.CreateSSHClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("ssh_next_hop: %w", err)
+	}
+
+	start := time.Now()
+	conn, err := client.DialContext(ctx, "tcp", pfConf.nextHop.remoteEndpoint)
+	if err != nil {
+		return nil, withPhase(ErrSSHConnect, fmt.Errorf("ssh_next_hop: could not connect to %s through %s: %w", pfConf.nextHop.remoteEndpoint, pfConf.remoteEndpoint, err))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, pfConf.nextHop.remoteEndpoint, sshConf)
+	if err != nil {
+		conn.Close()
+		return nil, withPhase(ErrSSHHandshake, fmt.Errorf("ssh_next_hop: %w", err))
+	}
+	logDuration("SSH handshake with the next hop", start)
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
 func (pfConf *portFowardConfig) validate() error {
 
 	var errors error
@@ -116,8 +448,8 @@ func (pfConf *portFowardConfig) validate() error {
 		errors = multierror.Append(errors, fmt.Errorf("not set ssh_user"))
 	}
 
-	if _, err := os.Stat(pfConf.keyPath); err != nil {
-		errors = multierror.Append(errors, fmt.Errorf("ssh_key_path: %s is not exist", pfConf.keyPath))
+	if pfConf.privateKey == "" && !anyFileExists(pfConf.keyPaths) && !pfConf.hasOtherAuth() {
+		errors = multierror.Append(errors, fmt.Errorf("ssh_key_path: %s is not exist", strings.Join(pfConf.keyPaths, ", ")))
 	}
 
 	if errors != nil {
@@ -127,26 +459,32 @@ func (pfConf *portFowardConfig) validate() error {
 	return nil
 }
 
-func (pfConf *portFowardConfig) Connect() error {
+func (pfConf *portFowardConfig) Connect(ctx context.Context) error {
 	if pfConf == nil {
 		return nil
 	}
 
-	sshConfig, err := pfConf.CreateSSHClientConfig()
-	if err != nil {
-		return err
-	}
+	return pfConf.serve(ctx, func(ctx context.Context) (*ssh.Client, func() error, error) {
+		sshConfig, err := pfConf.CreateSSHClientConfig()
+		if err != nil {
+			return nil, nil, err
+		}
 
-	client, err := pfConf.CreateSSHClient(sshConfig)
-	if err != nil {
-		return err
-	}
+		start := time.Now()
+		client, err := pfConf.CreateSSHClient(ctx, sshConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		logDuration("SSH dial and handshake", start)
 
-	if err := pfConf.PortForward(client); err != nil {
-		return err
-	}
+		return client, client.Close, nil
+	})
+}
 
-	return nil
+// logDuration logs how long a phase of the tunnel setup took, to tell a slow
+// SSM session apart from a slow bastion or database under TF_LOG=DEBUG.
+func logDuration(phase string, start time.Time) {
+	logf(logDebug, "tunnel: %s took %s", phase, time.Since(start).Round(time.Millisecond))
 }
 
 func defaultSSHKeyPath() string {
@@ -157,32 +495,269 @@ func defaultSSHKeyPath() string {
 	return path.Join(home, ".ssh", "id_rsa")
 }
 
+// setAdditionalForwards copies the additional_forward blocks into pfConf, one
+// "<local_port> <db_endpoint>" line each. A local port of 0 is picked by the
+// OS.
+func setAdditionalForwards(pfConf map[string]string, confMap map[string]interface{}) {
+	v, ok := confMap["additional_forward"].([]interface{})
+	if !ok {
+		return
+	}
+
+	var lines []string
+	for _, f := range v {
+		f, ok := f.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		endpoint, _ := f["db_endpoint"].(string)
+		port, _ := f["local_port"].(int)
+		lines = append(lines, fmt.Sprintf("%d %s", port, endpoint))
+	}
+	if len(lines) > 0 {
+		pfConf["additional_forwards"] = strings.Join(lines, "\n")
+	}
+}
+
+// setSSHAuth copies the authentication methods offered besides keys into
+// pfConf.
+func setSSHAuth(pfConf map[string]string, confMap map[string]interface{}) {
+	if v, ok := confMap["ssh_agent"].(bool); ok {
+		pfConf["ssh_agent"] = strconv.FormatBool(v)
+	}
+
+	if v, ok := confMap["ssh_password"].(string); ok && v != "" {
+		pfConf["ssh_password"] = v
+	}
+}
+
+// setSSHKeyPaths copies the key files of a tunnel block into pfConf. Multiple
+// paths are joined with newlines, which don't occur in real file names.
+func setSSHKeyPaths(pfConf map[string]string, confMap map[string]interface{}) {
+	if v, ok := confMap["ssh_key_path"].(string); ok && v != "" {
+		pfConf["ssh_key_path"] = v
+	}
+
+	var paths []string
+	if v, ok := confMap["ssh_key_paths"].([]interface{}); ok {
+		for _, p := range v {
+			if p, ok := p.(string); ok && p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	if len(paths) > 0 {
+		pfConf["ssh_key_paths"] = strings.Join(paths, "\n")
+	}
+
+	if pfConf["ssh_key_path"] == "" && pfConf["ssh_key_paths"] == "" {
+		pfConf["ssh_key_path"] = defaultSSHKeyPath()
+	}
+}
+
+func anyFileExists(paths []string) bool {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (conf *portFowardConfig) CreateSSHClientConfig() (*ssh.ClientConfig, error) {
-	key, err := ioutil.ReadFile(conf.keyPath)
+	auth, err := conf.authMethods()
 	if err != nil {
 		return nil, err
 	}
 
-	signer, err := ssh.ParsePrivateKey(key)
+	hostKeyCallback, err := conf.createHostKeyCallback()
 	if err != nil {
 		return nil, err
 	}
 
-	hostKeyCallback, err := createHostKeyCallback()
+	return &ssh.ClientConfig{
+		User:              conf.sshUser,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms(conf.hostKeys),
+	}, nil
+}
+
+// authMethods returns everything configured to log in with, in the order
+// OpenSSH tries them: the keys of the SSH agent, the private keys and the
+// password. The server picks whichever it accepts. Key files only have to be
+// usable when there is nothing else to offer.
+func (conf *portFowardConfig) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	var errors error
+
+	if conf.sshAgent {
+		signers, err := agentSigners()
+		if err != nil {
+			logf(logWarn, "not offering the keys of the SSH agent: %s", err)
+			errors = multierror.Append(errors, fmt.Errorf("ssh_agent: %w", err))
+		} else {
+			methods = append(methods, ssh.PublicKeysCallback(signers))
+		}
+	}
+
+	signers, err := conf.signers()
+	if err != nil {
+		errors = multierror.Append(errors, err)
+	} else {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if conf.sshPassword != "" {
+		// Servers with PasswordAuthentication off often still ask for the
+		// password through keyboard-interactive.
+		methods = append(methods,
+			ssh.Password(conf.sshPassword),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range questions {
+					if !echos[i] {
+						answers[i] = conf.sshPassword
+					}
+				}
+				return answers, nil
+			}),
+		)
+	}
+
+	if len(methods) == 0 {
+		return nil, errors
+	}
+	return methods, nil
+}
+
+// hasOtherAuth reports whether anything besides keys is offered to the
+// server.
+func (conf *portFowardConfig) hasOtherAuth() bool {
+	return conf.sshAgent || conf.sshPassword != ""
+}
+
+// agentSigners connects to the SSH agent at $SSH_AUTH_SOCK. The connection
+// has to stay open for the agent to sign during the handshake, so it is only
+// closed by Cleanup.
+func agentSigners() (func() ([]ssh.Signer, error), error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
 	if err != nil {
 		return nil, err
 	}
+	registerCleanup(conn.Close)
 
-	return &ssh.ClientConfig{
-		User: conf.sshUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: hostKeyCallback,
-	}, nil
+	return agent.NewClient(conn).Signers, nil
+}
+
+// signers parses the SSH private key, or every key file in turn so that the
+// server can pick the one it accepts. Unreadable or invalid files are skipped
+// with a warning as long as at least one key can be used.
+func (conf *portFowardConfig) signers() ([]ssh.Signer, error) {
+	if conf.privateKey != "" {
+		signer, err := parsePrivateKey([]byte(conf.privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("ssh_private_key: %w", err)
+		}
+		return []ssh.Signer{signer}, nil
+	}
+
+	var signers []ssh.Signer
+	var errors error
+	for _, p := range conf.keyPaths {
+		key, err := ioutil.ReadFile(p)
+		if err == nil {
+			warnKeyFileMode(p)
+
+			var signer ssh.Signer
+			signer, err = parsePrivateKey(key)
+			if err == nil {
+				signers = append(signers, signer)
+				continue
+			}
+		}
+		level := logWarn
+		if os.IsNotExist(err) && conf.hasOtherAuth() {
+			// Likely just the default key path.
+			level = logDebug
+		}
+		logf(level, "skipping SSH key %s: %s", p, err)
+		errors = multierror.Append(errors, fmt.Errorf("%s: %w", p, err))
+	}
+
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no usable SSH key: %w", errors)
+	}
+	return signers, nil
+}
+
+// parsePrivateKey parses an SSH private key, telling the usual reasons for
+// "ssh: no key found" and other parse failures apart.
+func parsePrivateKey(key []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer, nil
+	}
+
+	var passphraseErr *ssh.PassphraseMissingError
+	trimmed := bytes.TrimSpace(key)
+	switch {
+	case errors.As(err, &passphraseErr):
+		return nil, fmt.Errorf("the key is protected by a passphrase, which is not supported; remove it with ssh-keygen -p or load the key from a secret store into ssh_private_key")
+	case bytes.HasPrefix(trimmed, []byte("PuTTY-User-Key-File")):
+		return nil, fmt.Errorf("this is a PuTTY key; convert it to OpenSSH format with puttygen's export")
+	case isPublicKey(trimmed):
+		return nil, fmt.Errorf("this is a public key, the private key is needed")
+	case strings.Contains(err.Error(), "unsupported key type"):
+		return nil, fmt.Errorf("unsupported key type (%w); use an RSA, ECDSA or Ed25519 key", err)
+	case strings.Contains(err.Error(), "no key found"):
+		return nil, fmt.Errorf("not a private key in PEM or OpenSSH format (%w)", err)
+	}
+	return nil, err
+}
+
+func isPublicKey(key []byte) bool {
+	_, _, _, _, err := ssh.ParseAuthorizedKey(key)
+	return err == nil
 }
 
-func createHostKeyCallback() (ssh.HostKeyCallback, error) {
+// warnKeyFileMode logs a warning for a key file others can access, which
+// OpenSSH refuses to use. It is only a warning here, since e.g. mounted
+// secrets often can't be given stricter permissions.
+func warnKeyFileMode(p string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		logf(logWarn, "SSH key %s is accessible by others (mode %04o); restrict it with chmod 600", p, mode)
+	}
+}
+
+// createHostKeyCallback verifies host keys against ~/.ssh/known_hosts and
+// records unknown hosts on first use, where known_hosts_scope decides whether
+// they are recorded there or somewhere of the provider's own. With
+// ssh_host_public_key the host key must be one of the given keys instead, and
+// known_hosts isn't used at all. With disable_known_hosts, intended for
+// ephemeral runners without a persistent home directory, host keys are not
+// checked at all.
+func (conf *portFowardConfig) createHostKeyCallback() (ssh.HostKeyCallback, error) {
+	if len(conf.hostKeys) > 0 {
+		return fixedHostKeys(conf.hostKeys), nil
+	}
+
+	if conf.disableKnownHosts {
+		logf(logWarn, "SSH host key verification is disabled for %s", conf.remoteEndpoint)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, err
@@ -190,6 +765,13 @@ func createHostKeyCallback() (ssh.HostKeyCallback, error) {
 
 	knownHosts := path.Join(home, ".ssh", "known_hosts")
 
+	switch conf.knownHostsScope {
+	case knownHostsScopeTunnel:
+		return tunnelHostKeyCallback(knownHosts)
+	case knownHostsScopeMemory:
+		return memoryHostKeyCallback(knownHosts)
+	}
+
 	cb, err := knownhosts.New(knownHosts)
 	if err != nil {
 		return nil, err
@@ -227,6 +809,48 @@ func createHostKeyCallback() (ssh.HostKeyCallback, error) {
 	}, nil
 }
 
+// parseHostKeys parses one public key per line, in the authorized_keys format
+// of the bastion's /etc/ssh/ssh_host_*_key.pub files.
+func parseHostKeys(in string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	rest := []byte(in)
+	for len(bytes.TrimSpace(rest)) > 0 {
+		key, _, _, r, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		rest = r
+	}
+	return keys, nil
+}
+
+// fixedHostKeys accepts any of keys, like ssh.FixedHostKey does for one.
+func fixedHostKeys(keys []ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("ssh: host key %s of %s does not match ssh_host_public_key", ssh.FingerprintSHA256(key), hostname)
+	}
+}
+
+// hostKeyAlgorithms restricts the host key types offered to the server to
+// those of the given keys, so that a bastion with several host keys presents
+// one that can be verified. nil leaves the default list in place.
+func hostKeyAlgorithms(keys []ssh.PublicKey) []string {
+	var algos []string
+	for _, k := range keys {
+		if k.Type() == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, k.Type())
+	}
+	return algos
+}
+
 type addrImpl struct {
 	network string
 	addr    string
@@ -241,9 +865,64 @@ func (s *addrImpl) String() string {
 }
 
 func (pfConf *portFowardConfig) CreateSSHClient(
+	ctx context.Context,
 	sshConf *ssh.ClientConfig,
 ) (*ssh.Client, error) {
-	return ssh.Dial("tcp", pfConf.remoteEndpoint, sshConf)
+	conn, err := pfConf.dialSSH(ctx, sshConf.Timeout)
+	if err != nil {
+		return nil, withPhase(ErrSSHConnect, fmt.Errorf("could not connect to SSH host %s: %w", pfConf.remoteEndpoint, err))
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, pfConf.remoteEndpoint, sshConf)
+	if err != nil {
+		conn.Close()
+		return nil, pfConf.handshakeError(err)
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialSSH opens the TCP connection to the SSH host. Bastions that are still
+// booting tend to refuse the first connections, so the dial is retried up to
+// connectAttempts times, doubling the wait between attempts. The error of the
+// last attempt is returned when all of them fail.
+func (pfConf *portFowardConfig) dialSSH(ctx context.Context, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: keepAlivePeriod,
+	}
+
+	attempts := pfConf.connectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	interval := pfConf.connectRetryInterval
+
+	var err error
+	for i := 1; ; i++ {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", pfConf.remoteEndpoint)
+		if err == nil {
+			return conn, nil
+		}
+		if i >= attempts {
+			break
+		}
+
+		logf(logDebug, "SSH dial to %s failed (attempt %d/%d), retrying in %s: %s", pfConf.remoteEndpoint, i, attempts, interval, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+
+	return nil, err
+}
+
+func (pfConf *portFowardConfig) handshakeError(err error) error {
+	return withPhase(ErrSSHHandshake, fmt.Errorf("SSH handshake with %s as user %q failed: %w", pfConf.remoteEndpoint, pfConf.sshUser, err))
 }
 
 func (pfConf *portFowardConfig) CreateSSHClientWithProxyCommand(
@@ -267,7 +946,7 @@ func (pfConf *portFowardConfig) CreateSSHClientWithProxyCommand(
 	conn, chans, reqs, err := ssh.NewClientConn(c, pfConf.remoteEndpoint, sshConf)
 	if err != nil {
 		defer done()
-		return nil, nil, err
+		return nil, nil, pfConf.handshakeError(err)
 	}
 
 	client := ssh.NewClient(conn, chans, reqs)
@@ -275,55 +954,298 @@ func (pfConf *portFowardConfig) CreateSSHClientWithProxyCommand(
 	return client, done, nil
 }
 
-func (pfConf *portFowardConfig) PortForward(sshClient *ssh.Client) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", pfConf.localPort))
+// PortForward forwards connections to the local port to the DB endpoint, and
+// those to the additional ports to theirs, through the current SSH client
+// until ctx is canceled, which closes the listeners and every connection.
+func (pfConf *portFowardConfig) PortForward(ctx context.Context) error {
+	port, err := pfConf.listen(ctx, pfConf.localPort, pfConf.dbEndpoint)
 	if err != nil {
 		return err
 	}
+	// No port configured: remember the one picked by the OS.
+	pfConf.localPort = port
 
-	done := make(chan struct{})
+	for i, f := range pfConf.additionalForwards {
+		port, err := pfConf.listen(ctx, f.localPort, f.dbEndpoint)
+		if err != nil {
+			return fmt.Errorf("additional_forward to %s: %w", f.dbEndpoint, err)
+		}
+		pfConf.additionalForwards[i].localPort = port
+	}
+
+	if pfConf.socksPort > 0 {
+		return pfConf.serveSOCKS(ctx, func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return pfConf.client.Load().DialContext(ctx, network, addr)
+		})
+	}
+	return nil
+}
+
+// listen accepts connections on localPort of the bind address and forwards
+// them to dbEndpoint. It returns the port actually listened on.
+func (pfConf *portFowardConfig) listen(ctx context.Context, localPort uint16, dbEndpoint string) (uint16, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(pfConf.localBindAddress, strconv.Itoa(int(localPort))))
+	if err != nil {
+		return 0, withPhase(ErrPortBind, err)
+	}
+
+	registerCleanup(func() error {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		return nil
+	})
+	closeOnDone(ctx, listener)
 
 	go func() {
 		defer listener.Close()
 
 		for {
-			select {
-			case <-done:
-				return
-			default:
-			}
-
 			localConn, err := listener.Accept()
 			if err != nil {
 				var ne net.Error
 				if errors.As(err, &ne) && ne.Timeout() {
 					continue
 				}
-				fmt.Fprintln(os.Stderr, "accept failed: ", err)
+				if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				logf(logWarn, "accept on %s failed: %s", listener.Addr(), err)
+				pfConf.fail(fmt.Errorf("tunnel listener on %s failed: %w", listener.Addr(), err))
 				return
 			}
+			setKeepAlive(localConn)
 
-			remoteConn, err := sshClient.Dial("tcp", pfConf.dbEndpoint)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "dial failed: ", err)
-				return
-			}
+			go pfConf.forward(ctx, pfConf.client.Load(), localConn, dbEndpoint)
+		}
+	}()
 
-			go func() {
-				defer localConn.Close()
-				defer remoteConn.Close()
-				if _, err := io.Copy(remoteConn, localConn); err != nil {
-					fmt.Fprintln(os.Stderr, "copy failed: ", err)
-				}
-			}()
+	return uint16(listener.Addr().(*net.TCPAddr).Port), nil
+}
 
-			go func() {
-				if _, err := io.Copy(localConn, remoteConn); err != nil {
-					fmt.Fprintln(os.Stderr, "copy failed: ", err)
-				}
-			}()
+// forward connects localConn to dbEndpoint through the SSH client. The
+// dial is bounded by innerDialTimeout so that an endpoint which silently drops
+// packets closes the local connection instead of leaving the client hanging.
+func (pfConf *portFowardConfig) forward(ctx context.Context, sshClient *ssh.Client, localConn net.Conn, dbEndpoint string) {
+	dialCtx := ctx
+	if pfConf.innerDialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, pfConf.innerDialTimeout)
+		defer cancel()
+	}
+
+	remoteConn, err := sshClient.DialContext(dialCtx, "tcp", dbEndpoint)
+	if err != nil {
+		logf(logWarn, "dial %s through the tunnel failed: %s", dbEndpoint, err)
+		pfConf.fail(fmt.Errorf("could not connect to %s through the tunnel: %w", dbEndpoint, err))
+		localConn.Close()
+		return
+	}
+	setKeepAlive(remoteConn)
+
+	if pfConf.proxyProtocol != "" {
+		header, err := proxyProtocolHeader(pfConf.proxyProtocol, localConn.RemoteAddr(), localConn.LocalAddr())
+		if err == nil {
+			_, err = remoteConn.Write(header)
+		}
+		if err != nil {
+			logf(logWarn, "sending the PROXY protocol header to %s failed: %s", dbEndpoint, err)
+			remoteConn.Close()
+			localConn.Close()
+			return
 		}
+	}
+
+	pipe(ctx, localConn, remoteConn)
+}
+
+// pipe copies between a and b in both directions. Both connections are closed
+// as soon as either direction ends or ctx is canceled.
+func pipe(ctx context.Context, a, b net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		a.Close()
+		b.Close()
 	}()
 
+	copyConn := func(dst, src net.Conn) {
+		defer cancel()
+		if _, err := io.Copy(dst, src); err != nil && !errors.Is(err, net.ErrClosed) {
+			logf(logDebug, "copy between %s and %s failed: %s", src.RemoteAddr(), dst.RemoteAddr(), err)
+		}
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+}
+
+// closeOnDone closes c once ctx is canceled, which unblocks a pending Accept.
+func closeOnDone(ctx context.Context, c io.Closer) {
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+}
+
+// fail reports a tunnel failure to waitStartup. Only the first one is kept,
+// later ones are dropped once nobody is waiting.
+func (pfConf *portFowardConfig) fail(err error) {
+	select {
+	case pfConf.errs <- err:
+	default:
+	}
+}
+
+// waitStartup returns the first failure reported within startupErrorWindow, so
+// that a tunnel which breaks immediately is reported by providerConfigure
+// instead of as a confusing error from the MySQL driver later on.
+func (pfConf *portFowardConfig) waitStartup() error {
+	if pfConf.errs == nil {
+		return nil
+	}
+
+	select {
+	case err := <-pfConf.errs:
+		return err
+	case <-time.After(startupErrorWindow):
+		return nil
+	}
+}
+
+func (pfConf *portFowardConfig) listenAddr() string {
+	return net.JoinHostPort(pfConf.localBindAddress, strconv.Itoa(int(pfConf.localPort)))
+}
+
+// KeepOpen leaves a tunnel served by session-manager-plugin running after
+// the provider exits, for connecting to the database by hand. Tunnels over
+// SSH are served by the provider process itself and close with it anyway.
+func (pfConf *portFowardConfig) KeepOpen() {
+	pfConf.keepOpen = true
+}
+
+// LocalAddr returns the address clients connect to. A wildcard bind address
+// is reached through the loopback address of the same family.
+func (pfConf *portFowardConfig) LocalAddr() string {
+	return net.JoinHostPort(pfConf.localHost(), strconv.Itoa(int(pfConf.localPort)))
+}
+
+func (pfConf *portFowardConfig) localHost() string {
+	ip := net.ParseIP(pfConf.localBindAddress)
+	switch {
+	case ip == nil:
+		return defaultLocalBindAddress
+	case ip.IsUnspecified() && ip.To4() == nil:
+		return "::1"
+	case ip.IsUnspecified():
+		return defaultLocalBindAddress
+	}
+	return ip.String()
+}
+
+type tunnelInfo struct {
+	Host       string `json:"host"`
+	Port       uint16 `json:"port"`
+	DBEndpoint string `json:"db_endpoint"`
+}
+
+// WriteTunnelInfo writes the local end of the tunnel and the DB endpoint it
+// forwards to as JSON, so that wrapper scripts can use the same tunnel while
+// the provider is running. The file is removed again on Cleanup.
+func (pfConf *portFowardConfig) WriteTunnelInfo(path string) error {
+	var additional []tunnelInfo
+	for _, f := range pfConf.additionalForwards {
+		additional = append(additional, tunnelInfo{
+			Host:       pfConf.localHost(),
+			Port:       f.localPort,
+			DBEndpoint: f.dbEndpoint,
+		})
+	}
+
+	info, err := json.Marshal(struct {
+		tunnelInfo
+		AdditionalForwards []tunnelInfo `json:"additional_forwards,omitempty"`
+	}{
+		tunnelInfo: tunnelInfo{
+			Host:       pfConf.localHost(),
+			Port:       pfConf.localPort,
+			DBEndpoint: pfConf.dbEndpoint,
+		},
+		AdditionalForwards: additional,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, info, 0600); err != nil {
+		return fmt.Errorf("tunnel_info_path: %w", err)
+	}
+	registerCleanup(func() error {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+
 	return nil
 }
+
+// VerifyHandshake reads the initial handshake packet through the forwarded
+// port and checks that the target actually speaks the MySQL protocol.
+func (pfConf *portFowardConfig) VerifyHandshake() error {
+	if pfConf == nil || !pfConf.verifyHandshake {
+		return nil
+	}
+
+	addr := pfConf.LocalAddr()
+
+	// The session-manager-plugin opens its listener asynchronously, so give
+	// the forward a moment to come up before giving up.
+	var conn net.Conn
+	var err error
+	for i := 0; i < 10; i++ {
+		conn, err = net.DialTimeout("tcp", addr, 5*time.Second)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return withPhase(ErrReadinessTimeout, fmt.Errorf("could not connect to forwarded port %s: %s", addr, err))
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+
+	// packet header (3 byte length + 1 byte sequence) followed by the
+	// protocol version, or 0xff for an ERR packet (e.g. host is blocked).
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("target at db_endpoint %s does not appear to be a MySQL server: %s", pfConf.dbEndpoint, err)
+	}
+
+	if header[4] != mysqlProtocolVersion && header[4] != mysqlErrPacket {
+		return fmt.Errorf("target at db_endpoint %s does not appear to be a MySQL server", pfConf.dbEndpoint)
+	}
+
+	return nil
+}
+
+// setKeepAlive enables TCP keepalive on conn when it is a TCP connection.
+// Channels multiplexed over SSH are not, and rely on the keepalive of the
+// underlying SSH transport instead.
+func setKeepAlive(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		logf(logWarn, "could not enable keepalive on %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	if err := tcpConn.SetKeepAlivePeriod(keepAlivePeriod); err != nil {
+		logf(logWarn, "could not set keepalive period on %s: %s", conn.RemoteAddr(), err)
+	}
+}
@@ -0,0 +1,889 @@
+package port_forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func servePayload(t *testing.T, payload []byte) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(payload)
+	}()
+
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestVerifyHandshake(t *testing.T) {
+	cases := []struct {
+		name    string
+		payload []byte
+		wantErr bool
+	}{
+		{"mysql greeting", []byte{0x4a, 0x00, 0x00, 0x00, 0x0a, '8', '.', '0'}, false},
+		{"mysql error packet", []byte{0x17, 0x00, 0x00, 0x00, 0xff, 0x6a, 0x04}, false},
+		{"http response", []byte("HTTP/1.1 400 Bad Request\r\n\r\n"), true},
+		{"closed without data", []byte{}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := &portFowardConfig{
+				localPort:       servePayload(t, c.payload),
+				dbEndpoint:      "db.example.com:3306",
+				verifyHandshake: true,
+			}
+
+			err := conf.VerifyHandshake()
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !strings.Contains(err.Error(), "does not appear to be a MySQL server") {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestParsePFConfig_localBindAddress(t *testing.T) {
+	confMap := map[string]string{
+		"remote_endpoint":         "i-0123456789abcdef0:22",
+		"db_endpoint":             "db.example.com:3306",
+		"use_remote_port_forward": "true",
+	}
+
+	for bind, want := range map[string]string{
+		"":        "127.0.0.1:13306",
+		"::1":     "[::1]:13306",
+		"0.0.0.0": "127.0.0.1:13306",
+		"::":      "[::1]:13306",
+	} {
+		confMap["local_bind_address"] = bind
+		conf, err := ParsePFConfig(confMap, 13306)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.LocalAddr() != want {
+			t.Errorf("%q: got %s, want %s", bind, conf.LocalAddr(), want)
+		}
+	}
+
+	confMap["local_bind_address"] = "localhost"
+	if _, err := ParsePFConfig(confMap, 13306); err == nil {
+		t.Fatal("expected an error for a host name")
+	}
+}
+
+func TestLocalAddr_ipv6Loopback(t *testing.T) {
+	conf := &portFowardConfig{localPort: 13306, localBindAddress: "::1"}
+
+	l, err := net.Listen("tcp", conf.listenAddr())
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %s", err)
+	}
+	defer l.Close()
+
+	if conf.listenAddr() != "[::1]:13306" {
+		t.Fatalf("unexpected listen address %s", conf.listenAddr())
+	}
+
+	c, err := net.Dial("tcp", conf.LocalAddr())
+	if err != nil {
+		t.Fatalf("connecting to %s: %s", conf.LocalAddr(), err)
+	}
+	c.Close()
+}
+
+func TestVerifyHandshake_disabled(t *testing.T) {
+	conf := &portFowardConfig{localPort: 1, verifyHandshake: false}
+	if err := conf.VerifyHandshake(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestParsePFConfig_localPort(t *testing.T) {
+	confMap := map[string]string{
+		"remote_endpoint":         "i-0123456789abcdef0:22",
+		"db_endpoint":             "db.example.com:3306",
+		"use_remote_port_forward": "true",
+	}
+
+	conf, err := ParsePFConfig(confMap, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.localPort != 3306 {
+		t.Errorf("expected the endpoint port to be used by default, got %d", conf.localPort)
+	}
+
+	confMap["local_port"] = "13306"
+	conf, err = ParsePFConfig(confMap, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.localPort != 13306 {
+		t.Errorf("expected local_port to win, got %d", conf.localPort)
+	}
+	if conf.LocalAddr() != "127.0.0.1:13306" {
+		t.Errorf("unexpected local address %s", conf.LocalAddr())
+	}
+}
+
+func TestDialSSH_retry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	conf := &portFowardConfig{
+		remoteEndpoint:       addr,
+		connectAttempts:      2,
+		connectRetryInterval: 10 * time.Millisecond,
+	}
+	if _, err := conf.dialSSH(context.Background(), time.Second); err == nil {
+		t.Fatal("expected an error when nothing is listening")
+	}
+
+	// Start listening only after the first attempt has been refused.
+	ready := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			ready <- nil
+			return
+		}
+		ready <- l
+	}()
+
+	conf.connectAttempts = 5
+	conf.connectRetryInterval = 40 * time.Millisecond
+	conn, err := conf.dialSSH(context.Background(), time.Second)
+	if l := <-ready; l != nil {
+		defer l.Close()
+	} else {
+		t.Skip("could not re-listen on the released port")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.Close()
+}
+
+func TestCreateHostKeyCallback_disableKnownHosts(t *testing.T) {
+	// A fresh runner has no ~/.ssh/known_hosts at all.
+	t.Setenv("HOME", t.TempDir())
+
+	conf := &portFowardConfig{remoteEndpoint: "bastion.example.com:22"}
+	if _, err := conf.createHostKeyCallback(); err == nil {
+		t.Fatal("expected an error without a known_hosts file")
+	}
+
+	conf.disableKnownHosts = true
+	cb, err := conf.createHostKeyCallback()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := cb("bastion.example.com:22", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestCreateHostKeyCallback_hostPublicKey(t *testing.T) {
+	// known_hosts must not be needed at all.
+	t.Setenv("HOME", t.TempDir())
+
+	newKey := func() ssh.PublicKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	first, second, other := newKey(), newKey(), newKey()
+
+	conf, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":     "bastion.example.com:22",
+		"db_endpoint":         "db.example.com:3306",
+		"ssh_user":            "ec2-user",
+		"ssh_private_key":     "unused",
+		"ssh_host_public_key": string(ssh.MarshalAuthorizedKey(first)) + "\n" + string(ssh.MarshalAuthorizedKey(second)),
+	}, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.hostKeys) != 2 {
+		t.Fatalf("expected 2 host keys, got %d", len(conf.hostKeys))
+	}
+
+	cb, err := conf.createHostKeyCallback()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	for _, key := range []ssh.PublicKey{first, second} {
+		if err := cb("bastion.example.com:22", remote, key); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := cb("bastion.example.com:22", remote, other); err == nil {
+		t.Fatal("expected an unknown host key to be rejected")
+	}
+
+	if algos := hostKeyAlgorithms(conf.hostKeys); len(algos) != 2 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Fatalf("unexpected host key algorithms: %v", algos)
+	}
+
+	if _, err := parseHostKeys("ssh-ed25519 not-base64"); err == nil {
+		t.Fatal("expected an invalid key to be rejected")
+	}
+}
+
+func TestWriteTunnelInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.json")
+	conf := &portFowardConfig{localPort: 13306, dbEndpoint: "db.example.com:3306"}
+
+	if err := conf.WriteTunnelInfo(path); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"host":"127.0.0.1","port":13306,"db_endpoint":"db.example.com:3306"}`
+	if string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+
+	if err := Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed on Cleanup", path)
+	}
+}
+
+func TestWriteTunnelInfo_additionalForwards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.json")
+	conf := &portFowardConfig{
+		localPort:          13306,
+		dbEndpoint:         "db1.example.com:3306",
+		additionalForwards: []additionalForward{{13307, "db2.example.com:3306"}},
+	}
+	defer Cleanup()
+
+	if err := conf.WriteTunnelInfo(path); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"host":"127.0.0.1","port":13306,"db_endpoint":"db1.example.com:3306","additional_forwards":[{"host":"127.0.0.1","port":13307,"db_endpoint":"db2.example.com:3306"}]}`
+	if string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+}
+
+func TestWaitStartup(t *testing.T) {
+	conf := &portFowardConfig{errs: make(chan error, 1)}
+
+	want := errors.New("listener closed")
+	conf.fail(want)
+	conf.fail(errors.New("dropped"))
+
+	if err := conf.waitStartup(); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+
+	if err := (&portFowardConfig{}).waitStartup(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestCreateSSHClientConfig_keyPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(good, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "id_invalid")
+	if err := os.WriteFile(invalid, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "id_missing")
+
+	conf, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":     "bastion.example.com:22",
+		"db_endpoint":         "db.example.com:3306",
+		"ssh_user":            "ec2-user",
+		"ssh_key_path":        missing,
+		"ssh_key_paths":       invalid + "\n" + good,
+		"disable_known_hosts": "true",
+	}, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conf.CreateSSHClientConfig(); err != nil {
+		t.Fatalf("expected the valid key to be used: %s", err)
+	}
+
+	conf.keyPaths = []string{invalid, missing}
+	if _, err := conf.CreateSSHClientConfig(); err == nil {
+		t.Fatal("expected an error without any usable key")
+	}
+}
+
+func TestCreateSSHClientConfig_authMethods(t *testing.T) {
+	_, agentKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentSigner, err := ssh.NewSignerFromKey(agentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: agentKey}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets are not available: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	t.Cleanup(func() { Cleanup() })
+
+	missing := filepath.Join(t.TempDir(), "id_missing")
+
+	cases := []struct {
+		name     string
+		conf     map[string]string
+		server   func(*ssh.ServerConfig)
+		wantAuth bool
+	}{
+		{
+			name: "password after missing key",
+			conf: map[string]string{"ssh_password": "secret"},
+			server: func(c *ssh.ServerConfig) {
+				c.PasswordCallback = func(_ ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+					if string(pw) != "secret" {
+						return nil, errors.New("wrong password")
+					}
+					return nil, nil
+				}
+			},
+			wantAuth: true,
+		},
+		{
+			name: "keyboard-interactive password",
+			conf: map[string]string{"ssh_password": "secret"},
+			server: func(c *ssh.ServerConfig) {
+				c.KeyboardInteractiveCallback = func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+					answers, err := client("", "", []string{"Password: "}, []bool{false})
+					if err != nil || len(answers) != 1 || answers[0] != "secret" {
+						return nil, errors.New("wrong password")
+					}
+					return nil, nil
+				}
+			},
+			wantAuth: true,
+		},
+		{
+			name: "agent key before password",
+			conf: map[string]string{"ssh_agent": "true", "ssh_password": "secret"},
+			server: func(c *ssh.ServerConfig) {
+				c.PublicKeyCallback = func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+					if !bytes.Equal(key.Marshal(), agentSigner.PublicKey().Marshal()) {
+						return nil, errors.New("unknown key")
+					}
+					return nil, nil
+				}
+				c.PasswordCallback = func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+					t.Error("password offered before the agent key")
+					return nil, errors.New("wrong password")
+				}
+			},
+			wantAuth: true,
+		},
+		{
+			name: "nothing accepted",
+			conf: map[string]string{"ssh_agent": "true"},
+			server: func(c *ssh.ServerConfig) {
+				c.PasswordCallback = func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+					return nil, errors.New("unexpected password")
+				}
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			confMap := map[string]string{
+				"remote_endpoint":     "bastion.example.com:22",
+				"db_endpoint":         "db.example.com:3306",
+				"ssh_user":            "ec2-user",
+				"ssh_key_path":        missing,
+				"disable_known_hosts": "true",
+			}
+			for k, v := range c.conf {
+				confMap[k] = v
+			}
+			conf, err := ParsePFConfig(confMap, 3306)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConf, err := conf.CreateSSHClientConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hostSigner, err := ssh.NewSignerFromKey(hostKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverConf := &ssh.ServerConfig{}
+			serverConf.AddHostKey(hostSigner)
+			c.server(serverConf)
+
+			// net.Pipe doesn't buffer, which deadlocks the version exchange.
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				server, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer server.Close()
+				ssh.NewServerConn(server, serverConf)
+			}()
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			_, _, _, err = ssh.NewClientConn(client, "bastion.example.com:22", clientConf)
+			if c.wantAuth && err != nil {
+				t.Fatalf("expected to log in: %s", err)
+			}
+			if !c.wantAuth && err == nil {
+				t.Fatal("expected the login to fail")
+			}
+		})
+	}
+
+	if _, err := ParsePFConfig(map[string]string{
+		"remote_endpoint": "bastion.example.com:22",
+		"db_endpoint":     "db.example.com:3306",
+		"ssh_user":        "ec2-user",
+		"ssh_key_path":    missing,
+	}, 3306); err == nil {
+		t.Fatal("expected an error without a key file or any other method")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr string
+	}{
+		{"valid", pem.EncodeToMemory(block), ""},
+		{"passphrase", pem.EncodeToMemory(encrypted), "passphrase"},
+		{"public key", ssh.MarshalAuthorizedKey(sshPub), "public key"},
+		{"putty", []byte("PuTTY-User-Key-File-3: ssh-ed25519\n"), "PuTTY"},
+		{"unsupported", pem.EncodeToMemory(&pem.Block{Type: "FOO PRIVATE KEY", Bytes: []byte{0}}), "unsupported key type"},
+		{"garbage", []byte("not a key"), "PEM or OpenSSH format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePrivateKey(tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWarnKeyFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not checked on Windows")
+	}
+	p := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(p, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	warnKeyFileMode(p)
+	if !strings.Contains(buf.String(), "accessible by others") {
+		t.Fatalf("expected a warning, got %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := os.Chmod(p, 0600); err != nil {
+		t.Fatal(err)
+	}
+	warnKeyFileMode(p)
+	if buf.Len() != 0 {
+		t.Fatalf("expected no warning, got %q", buf.String())
+	}
+}
+
+func TestPipe_cancel(t *testing.T) {
+	local, localPeer := net.Pipe()
+	remote, remotePeer := net.Pipe()
+	defer localPeer.Close()
+	defer remotePeer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pipe(ctx, local, remote)
+
+	go localPeer.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := remotePeer.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expected ping through the pipe, got %q: %v", buf, err)
+	}
+
+	cancel()
+	localPeer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := localPeer.Read(buf); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the connection to be closed on cancel, got %v", err)
+	}
+}
+
+func TestParsePFConfig_nextHop(t *testing.T) {
+	conf, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":     "i-0123456789abcdef0:22",
+		"db_endpoint":         "db.internal:3306",
+		"ssh_user":            "ec2-user",
+		"ssh_private_key":     "first key",
+		"disable_known_hosts": "true",
+		"next_hop_host":       "10.0.1.5",
+	}, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hop := conf.nextHop
+	if hop == nil {
+		t.Fatal("expected a next hop")
+	}
+	if hop.remoteEndpoint != "10.0.1.5:22" || hop.sshUser != "ec2-user" || hop.privateKey != "first key" || !hop.disableKnownHosts {
+		t.Fatalf("expected the next hop to inherit from the first one, got %+v", hop)
+	}
+
+	conf, err = ParsePFConfig(map[string]string{
+		"remote_endpoint":          "i-0123456789abcdef0:22",
+		"db_endpoint":              "db.internal:3306",
+		"ssh_user":                 "ec2-user",
+		"ssh_private_key":          "first key",
+		"next_hop_host":            "[fd00::5]:2222",
+		"next_hop_ssh_user":        "admin",
+		"next_hop_ssh_private_key": "second key",
+	}, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hop = conf.nextHop
+	if hop.remoteEndpoint != "[fd00::5]:2222" || hop.sshUser != "admin" || hop.privateKey != "second key" {
+		t.Fatalf("unexpected next hop %+v", hop)
+	}
+
+	_, err = ParsePFConfig(map[string]string{
+		"remote_endpoint":       "i-0123456789abcdef0:22",
+		"db_endpoint":           "db.internal:3306",
+		"ssh_user":              "ec2-user",
+		"ssh_private_key":       "first key",
+		"next_hop_host":         "10.0.1.5",
+		"next_hop_ssh_key_path": filepath.Join(t.TempDir(), "missing"),
+	}, 3306)
+	if err == nil || !strings.Contains(err.Error(), "ssh_next_hop") {
+		t.Fatalf("expected a missing next hop key to be reported, got %v", err)
+	}
+}
+
+func TestParsePFConfig_additionalForwards(t *testing.T) {
+	pfConf := map[string]string{
+		"remote_endpoint": "i-0123456789abcdef0:22",
+		"db_endpoint":     "db1.internal:3306",
+		"ssh_user":        "ec2-user",
+		"ssh_private_key": "key",
+	}
+	setAdditionalForwards(pfConf, map[string]interface{}{
+		"additional_forward": []interface{}{
+			map[string]interface{}{"db_endpoint": "db2.internal:3306", "local_port": 13307},
+			map[string]interface{}{"db_endpoint": "db3.internal", "local_port": 0},
+		},
+	})
+
+	conf, err := ParsePFConfig(pfConf, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []additionalForward{{13307, "db2.internal:3306"}, {0, "db3.internal:3306"}}
+	if len(conf.additionalForwards) != len(want) {
+		t.Fatalf("got %+v, want %+v", conf.additionalForwards, want)
+	}
+	for i := range want {
+		if conf.additionalForwards[i] != want[i] {
+			t.Fatalf("got %+v, want %+v", conf.additionalForwards, want)
+		}
+	}
+
+	pfConf["use_remote_port_forward"] = "true"
+	if _, err := ParsePFConfig(pfConf, 3306); err == nil || !strings.Contains(err.Error(), "additional_forward") {
+		t.Fatalf("expected additional forwards to be rejected for remote port forwarding, got %v", err)
+	}
+}
+
+func TestParsePFConfig_dbEndpointPort(t *testing.T) {
+	cases := []struct {
+		dbEndpoint string
+		want       string
+	}{
+		{"db.example.com", "db.example.com:3306"},
+		{"db.example.com:3307", "db.example.com:3307"},
+		{"[2001:db8::1]", "[2001:db8::1]:3306"},
+	}
+
+	for _, c := range cases {
+		conf, err := ParsePFConfig(map[string]string{
+			"remote_endpoint": "bastion.example.com:22",
+			"db_endpoint":     c.dbEndpoint,
+			"ssh_user":        "ec2-user",
+			"ssh_private_key": "unused",
+		}, 13306)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf.dbEndpoint != c.want {
+			t.Errorf("%s: got db endpoint %s, want %s", c.dbEndpoint, conf.dbEndpoint, c.want)
+		}
+		if conf.localPort != 13306 {
+			t.Errorf("%s: expected the local port of the endpoint to be kept, got %d", c.dbEndpoint, conf.localPort)
+		}
+	}
+}
+
+func TestPortForward_localPortDiffers(t *testing.T) {
+	conns := make(chan *ssh.ServerConn, 1)
+	sshAddr := startSSHServer(t, conns)
+
+	c, err := net.Dial("tcp", sshAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(c, sshAddr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	// A free local port, which is not the one the database listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localPort := uint16(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	dbPort := servePayload(t, []byte("db"))
+	if localPort == dbPort {
+		t.Skip("the OS picked the same port twice")
+	}
+
+	conf, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":     "bastion.example.com:22",
+		"db_endpoint":         net.JoinHostPort("127.0.0.1", strconv.Itoa(int(dbPort))),
+		"ssh_user":            "ec2-user",
+		"ssh_private_key":     "unused",
+		"local_bind_address":  "127.0.0.1",
+		"disable_known_hosts": "true",
+	}, localPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.client.Store(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer Cleanup()
+	defer cancel()
+
+	if err := conf.PortForward(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conf.localPort != localPort {
+		t.Fatalf("expected to listen on %d, got %d", localPort, conf.localPort)
+	}
+
+	local, err := net.Dial("tcp", conf.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	local.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(local)
+	if err != nil || string(got) != "db" {
+		t.Fatalf("got %q, want %q: %v", got, "db", err)
+	}
+}
+
+func TestPortForward_additionalForwards(t *testing.T) {
+	conns := make(chan *ssh.ServerConn, 2)
+	sshAddr := startSSHServer(t, conns)
+
+	c, err := net.Dial("tcp", sshAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(c, sshAddr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	db1 := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(servePayload(t, []byte("db1")))))
+	db2 := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(servePayload(t, []byte("db2")))))
+	conf := &portFowardConfig{
+		localBindAddress:   defaultLocalBindAddress,
+		dbEndpoint:         db1,
+		additionalForwards: []additionalForward{{0, db2}},
+		innerDialTimeout:   time.Second,
+		errs:               make(chan error, 1),
+	}
+	conf.client.Store(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer Cleanup()
+	defer cancel()
+
+	if err := conf.PortForward(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conf.additionalForwards[0].localPort == 0 {
+		t.Fatal("expected the picked port of the additional forward to be recorded")
+	}
+
+	for port, want := range map[uint16]string{
+		conf.localPort:                       "db1",
+		conf.additionalForwards[0].localPort: "db2",
+	} {
+		local, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		local.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := io.ReadAll(local)
+		local.Close()
+		if err != nil || string(got) != want {
+			t.Fatalf("port %d: got %q, want %q: %v", port, got, want, err)
+		}
+	}
+
+	<-conns
+	select {
+	case <-conns:
+		t.Fatal("expected a single SSH connection for all forwards")
+	default:
+	}
+}
@@ -0,0 +1,66 @@
+package port_forward
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	proxyProtocolV1 = "v1"
+	proxyProtocolV2 = "v2"
+)
+
+var proxyProtocolV2Signature = []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+
+// proxyProtocolHeader returns the PROXY protocol header of the given version
+// that tells the receiving proxy about a connection from src to dst. Addresses
+// that can't be expressed, e.g. of different families, are sent as unknown
+// (v1) or as a LOCAL connection (v2), which makes the proxy use the actual
+// connection's addresses instead.
+func proxyProtocolHeader(version string, src, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	family := 0
+	if srcOK && dstOK {
+		switch {
+		case srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil:
+			family = 4
+		case srcTCP.IP.To4() == nil && dstTCP.IP.To4() == nil:
+			family = 6
+		}
+	}
+
+	switch version {
+	case proxyProtocolV1:
+		if family == 0 {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		return []byte(fmt.Sprintf("PROXY TCP%d %s %s %d %d\r\n", family, srcTCP.IP, dstTCP.IP, srcTCP.Port, dstTCP.Port)), nil
+
+	case proxyProtocolV2:
+		var b bytes.Buffer
+		b.Write(proxyProtocolV2Signature)
+		if family == 0 {
+			// LOCAL command, no address block.
+			b.Write([]byte{0x20, 0x00, 0x00, 0x00})
+			return b.Bytes(), nil
+		}
+
+		srcIP, dstIP, fam := srcTCP.IP.To4(), dstTCP.IP.To4(), byte(0x11)
+		if family == 6 {
+			srcIP, dstIP, fam = srcTCP.IP.To16(), dstTCP.IP.To16(), 0x21
+		}
+		// PROXY command over TCP of the address family.
+		b.Write([]byte{0x21, fam})
+		binary.Write(&b, binary.BigEndian, uint16(2*len(srcIP)+4))
+		b.Write(srcIP)
+		b.Write(dstIP)
+		binary.Write(&b, binary.BigEndian, uint16(srcTCP.Port))
+		binary.Write(&b, binary.BigEndian, uint16(dstTCP.Port))
+		return b.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("send_proxy_protocol: unsupported version %q, expected %s or %s", version, proxyProtocolV1, proxyProtocolV2)
+}
@@ -0,0 +1,146 @@
+package port_forward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 51234}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3306}
+	v6src := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 51234}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 3306}
+
+	cases := []struct {
+		version  string
+		src, dst net.Addr
+		want     []byte
+	}{
+		{"v1", v4src, v4dst, []byte("PROXY TCP4 127.0.0.1 127.0.0.1 51234 3306\r\n")},
+		{"v1", v6src, v6dst, []byte("PROXY TCP6 ::1 ::1 51234 3306\r\n")},
+		{"v1", v4src, v6dst, []byte("PROXY UNKNOWN\r\n")},
+		{"v2", v4src, v4dst, append(append([]byte{}, proxyProtocolV2Signature...),
+			0x21, 0x11, 0x00, 0x0c,
+			127, 0, 0, 1,
+			127, 0, 0, 1,
+			0xc8, 0x22,
+			0x0c, 0xea)},
+		{"v2", v6src, v6dst, append(append([]byte{}, proxyProtocolV2Signature...),
+			0x21, 0x21, 0x00, 0x24,
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+			0xc8, 0x22,
+			0x0c, 0xea)},
+		{"v2", v6src, v4dst, append(append([]byte{}, proxyProtocolV2Signature...), 0x20, 0x00, 0x00, 0x00)},
+	}
+
+	for _, c := range cases {
+		got, err := proxyProtocolHeader(c.version, c.src, c.dst)
+		if err != nil {
+			t.Fatalf("%s %s -> %s: %s", c.version, c.src, c.dst, err)
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("%s %s -> %s: got %q, want %q", c.version, c.src, c.dst, got, c.want)
+		}
+	}
+
+	if _, err := proxyProtocolHeader("v3", v4src, v4dst); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+}
+
+func TestForward_proxyProtocol(t *testing.T) {
+	db, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	headers := make(chan string, 1)
+	go func() {
+		conn, err := db.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		headers <- line
+	}()
+
+	sshAddr := startSSHServer(t, make(chan *ssh.ServerConn, 1))
+	c, err := net.Dial("tcp", sshAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, chans, reqs, err := ssh.NewClientConn(c, sshAddr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ssh.NewClient(conn, chans, reqs)
+	defer client.Close()
+
+	conf, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":     sshAddr,
+		"db_endpoint":         db.Addr().String(),
+		"ssh_user":            "test",
+		"ssh_private_key":     "unused",
+		"send_proxy_protocol": "v1",
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.client.Store(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer Cleanup()
+	defer cancel()
+	if err := conf.PortForward(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := net.Dial("tcp", conf.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+
+	select {
+	case got := <-headers:
+		src := local.LocalAddr().(*net.TCPAddr)
+		want := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %d\r\n", src.Port, conf.localPort)
+		if got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a PROXY protocol header")
+	}
+
+	if _, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":     sshAddr,
+		"db_endpoint":         db.Addr().String(),
+		"ssh_user":            "test",
+		"ssh_private_key":     "unused",
+		"send_proxy_protocol": "v3",
+	}, 0); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+
+	if _, err := ParsePFConfig(map[string]string{
+		"remote_endpoint":         "i-0123456789abcdef0:22",
+		"db_endpoint":             db.Addr().String(),
+		"use_remote_port_forward": "true",
+		"send_proxy_protocol":     "v1",
+	}, 0); err == nil || !strings.Contains(err.Error(), "send_proxy_protocol") {
+		t.Fatalf("expected send_proxy_protocol to be rejected for remote port forwarding, got %v", err)
+	}
+}
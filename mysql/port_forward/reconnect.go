@@ -0,0 +1,217 @@
+package port_forward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/crypto/ssh"
+)
+
+// reconnectMaxInterval caps the wait between attempts to re-establish a
+// dropped tunnel.
+const reconnectMaxInterval = time.Minute
+
+// sshDialer makes the SSH connection to the first hop of a tunnel. Besides
+// the client it returns a function that closes the client and tears down
+// whatever else the connection needs, e.g. the SSM session.
+type sshDialer func(ctx context.Context) (*ssh.Client, func() error, error)
+
+// serve connects with dial and forwards the local port through the
+// connection. With auto_reconnect, the connection is made again with dial
+// whenever it drops, while the local port stays the same.
+func (pfConf *portFowardConfig) serve(ctx context.Context, dial sshDialer) error {
+	closeConn, err := pfConf.connectSSH(ctx, dial)
+	if err != nil {
+		return err
+	}
+
+	if err := pfConf.PortForward(ctx); err != nil {
+		var errors error = err
+
+		if err := closeConn(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+		return errors
+	}
+	current := &currentConn{close: closeConn}
+	registerCleanup(current.Close)
+
+	if pfConf.autoReconnect {
+		go pfConf.superviseSSH(ctx, dial, current)
+	}
+	return nil
+}
+
+// currentConn holds the teardown function of the connection a tunnel uses at
+// the moment. It is registered with Cleanup once, before Connect registers
+// the cancellation of the supervisor, so that Cleanup always cancels first:
+// teardowns registered on reconnect would run before that and have the
+// supervisor reconnect in the middle of shutting down.
+type currentConn struct {
+	mu     sync.Mutex
+	close  func() error
+	closed bool
+}
+
+// replace makes close the teardown of the current connection. It returns
+// false, after calling close, if the tunnel has been closed meanwhile.
+func (c *currentConn) replace(close func() error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		close()
+		return false
+	}
+	c.close = close
+	return true
+}
+
+// closing reports whether Close has been called.
+func (c *currentConn) closing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// drop tears down the current connection, which is to be replaced.
+func (c *currentConn) drop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.close()
+}
+
+// Close tears down the current connection, and any made after it.
+func (c *currentConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return c.close()
+}
+
+// connectSSH connects with dial, continues to the next hop if there is one,
+// and makes the result the client connections are forwarded through. The
+// returned function closes it all and may be called more than once.
+func (pfConf *portFowardConfig) connectSSH(ctx context.Context, dial sshDialer) (func() error, error) {
+	first, closeFirst, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := pfConf.dialNextHop(ctx, first)
+	if err != nil {
+		var errors error = err
+
+		if err := closeFirst(); err != nil {
+			errors = multierror.Append(errors, err)
+		}
+		return nil, errors
+	}
+
+	pfConf.client.Store(client)
+	return sync.OnceValue(func() error {
+		var errs error
+		if client != first {
+			if err := client.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				errs = multierror.Append(errs, err)
+			}
+		}
+		if err := closeFirst(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = multierror.Append(errs, err)
+		}
+		return errs
+	}), nil
+}
+
+// superviseSSH waits for the SSH connection to drop and makes a new one,
+// until ctx is canceled. Connections open at the time fail, new ones go
+// through the new SSH connection.
+func (pfConf *portFowardConfig) superviseSSH(ctx context.Context, dial sshDialer, current *currentConn) {
+	for {
+		client := pfConf.client.Load()
+		go sendKeepAlives(ctx, client)
+
+		err := client.Wait()
+		if ctx.Err() != nil || current.closing() {
+			return
+		}
+
+		logf(logWarn, "SSH connection to %s dropped, reconnecting: %v", pfConf.remoteEndpoint, err)
+		current.drop()
+		var closeConn func() error
+		err = retryReconnect(ctx, pfConf.connectRetryInterval, func() error {
+			var err error
+			closeConn, err = pfConf.connectSSH(ctx, dial)
+			return err
+		})
+		if err != nil || !current.replace(closeConn) {
+			return
+		}
+	}
+}
+
+// retryReconnect calls connect until it succeeds or ctx is canceled, doubling
+// the wait between attempts up to reconnectMaxInterval.
+func retryReconnect(ctx context.Context, interval time.Duration, connect func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := connect()
+		if err == nil {
+			logf(logInfo, "tunnel reconnected after %d attempt(s)", attempt)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		logf(logWarn, "reconnecting the tunnel failed (attempt %d), retrying in %s: %s", attempt, interval, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, reconnectMaxInterval)
+	}
+}
+
+// sendKeepAlives closes client when the server stops answering keepalive
+// requests, so that a connection that silently died is noticed by Wait and
+// reconnected instead of hanging every new connection.
+func sendKeepAlives(ctx context.Context, client *ssh.Client) {
+	ticker := time.NewTicker(keepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-replied:
+			if err != nil {
+				// The connection is already gone, Wait has returned.
+				return
+			}
+		case <-time.After(keepAlivePeriod):
+			logf(logWarn, "SSH keepalive got no reply within %s, closing the connection", keepAlivePeriod)
+			client.Close()
+			return
+		}
+	}
+}
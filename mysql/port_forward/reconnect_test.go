@@ -0,0 +1,248 @@
+package port_forward
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer runs an SSH server that only supports direct-tcpip channels
+// and sends every connection it accepts on conns, so that tests can drop them.
+func startSSHServer(t *testing.T, conns chan<- *ssh.ServerConn) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &ssh.ServerConfig{NoClientAuth: true}
+	conf.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sconn, chans, reqs, err := ssh.NewServerConn(c, conf)
+				if err != nil {
+					return
+				}
+				conns <- sconn
+				go ssh.DiscardRequests(reqs)
+
+				for nc := range chans {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if nc.ChannelType() != "direct-tcpip" || ssh.Unmarshal(nc.ExtraData(), &target) != nil {
+						nc.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+					if err != nil {
+						nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, creqs, err := nc.Accept()
+					if err != nil {
+						remote.Close()
+						continue
+					}
+					go ssh.DiscardRequests(creqs)
+					go func() {
+						io.Copy(ch, remote)
+						ch.Close()
+					}()
+					go func() {
+						io.Copy(remote, ch)
+						remote.Close()
+					}()
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestServe_autoReconnect(t *testing.T) {
+	db, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	go func() {
+		for {
+			conn, err := db.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello"))
+			conn.Close()
+		}
+	}()
+
+	conns := make(chan *ssh.ServerConn, 4)
+	sshAddr := startSSHServer(t, conns)
+
+	conf := &portFowardConfig{
+		localBindAddress:     defaultLocalBindAddress,
+		remoteEndpoint:       sshAddr,
+		dbEndpoint:           db.Addr().String(),
+		autoReconnect:        true,
+		connectRetryInterval: 10 * time.Millisecond,
+		innerDialTimeout:     time.Second,
+		errs:                 make(chan error, 1),
+	}
+	dial := func(ctx context.Context) (*ssh.Client, func() error, error) {
+		c, err := net.Dial("tcp", sshAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, chans, reqs, err := ssh.NewClientConn(c, sshAddr, &ssh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		client := ssh.NewClient(conn, chans, reqs)
+		return client, client.Close, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer Cleanup()
+	defer cancel()
+
+	if err := conf.serve(ctx, dial); err != nil {
+		t.Fatal(err)
+	}
+	addr := conf.LocalAddr()
+
+	read := func() (string, error) {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b, err := io.ReadAll(conn)
+		return string(b), err
+	}
+
+	if got, err := read(); err != nil || got != "hello" {
+		t.Fatalf("expected hello through the tunnel, got %q: %v", got, err)
+	}
+
+	// Drop the SSH connection from the server side.
+	(<-conns).Close()
+
+	select {
+	case <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the tunnel to reconnect")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := read()
+		if err == nil && got == "hello" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected hello through the reconnected tunnel on %s, got %q: %v", addr, got, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if conf.LocalAddr() != addr {
+		t.Fatalf("expected the local address to stay %s, got %s", addr, conf.LocalAddr())
+	}
+}
+
+func TestServe_cleanupAfterReconnect(t *testing.T) {
+	conns := make(chan *ssh.ServerConn, 4)
+	sshAddr := startSSHServer(t, conns)
+
+	conf := &portFowardConfig{
+		localBindAddress:     defaultLocalBindAddress,
+		remoteEndpoint:       sshAddr,
+		dbEndpoint:           "127.0.0.1:1",
+		autoReconnect:        true,
+		connectRetryInterval: 10 * time.Millisecond,
+		innerDialTimeout:     time.Second,
+		errs:                 make(chan error, 1),
+	}
+	var dials atomic.Int32
+	dial := func(ctx context.Context) (*ssh.Client, func() error, error) {
+		dials.Add(1)
+		c, err := net.Dial("tcp", sshAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, chans, reqs, err := ssh.NewClientConn(c, sshAddr, &ssh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		client := ssh.NewClient(conn, chans, reqs)
+		// Slow, like terminating an SSM session, so that a reconnect
+		// started by closing the client would happen within Cleanup.
+		return client, func() error {
+			err := client.Close()
+			time.Sleep(100 * time.Millisecond)
+			return err
+		}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := conf.serve(ctx, dial); err != nil {
+		t.Fatal(err)
+	}
+	// As Connect does after serve.
+	registerCleanup(func() error {
+		cancel()
+		return nil
+	})
+
+	(<-conns).Close()
+	select {
+	case <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the tunnel to reconnect")
+	}
+	// Give the supervisor time to make the new connection current.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := dials.Load(); n != 2 {
+		t.Fatalf("expected no dial after Cleanup, got %d dials in total", n)
+	}
+}
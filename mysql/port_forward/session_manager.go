@@ -1,25 +1,59 @@
 package port_forward
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"os/user"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/hashicorp/go-multierror"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
 )
 
+const (
+	defaultSSHDocumentName         = "AWS-StartSSHSession"
+	defaultPortForwardDocumentName = "AWS-StartPortForwardingSessionToRemoteHost"
+)
+
+// assumeRoleExpiryWindow is how long before they expire assumed role
+// credentials are refreshed, so that no request is signed with credentials
+// about to expire.
+const assumeRoleExpiryWindow = 5 * time.Minute
+
 type sessionConfig struct {
-	instanceID string
-	session    *session.Session
+	instanceID   string
+	documentName string
+	ssmEndpoint  string
+	profile      string
+	session      *session.Session
+	// pluginEnv holds extra environment variables for session-manager-plugin
+	pluginEnv []string
 }
 
 func ParseSessionConfig(d *schema.ResourceData) (*sessionConfig, map[string]string, error) {
@@ -39,11 +73,17 @@ func ParseSessionConfig(d *schema.ResourceData) (*sessionConfig, map[string]stri
 	if v, ok := confMap["ec2_instance_id"].(string); ok && v != "" {
 		sessionConf.instanceID = v
 	}
-	pfConf["remote_endpoint"] = fmt.Sprintf("%s:22", sessionConf.instanceID)
 
-	profile := ""
+	if v, ok := confMap["ssm_document_name"].(string); ok && v != "" {
+		sessionConf.documentName = v
+	}
+
+	if v, ok := confMap["aws_ssm_endpoint_url"].(string); ok && v != "" {
+		sessionConf.ssmEndpoint = v
+	}
+
 	if v, ok := confMap["aws_profile"].(string); ok && v != "" {
-		profile = v
+		sessionConf.profile = v
 	}
 
 	region := ""
@@ -51,24 +91,128 @@ func ParseSessionConfig(d *schema.ResourceData) (*sessionConfig, map[string]stri
 		region = v
 	}
 
-	sessionConf.session, _ = session.NewSessionWithOptions(session.Options{
+	credentialsFile := ""
+	if v, ok := confMap["aws_shared_credentials_file"].(string); ok && v != "" {
+		credentialsFile = v
+		sessionConf.pluginEnv = append(sessionConf.pluginEnv, "AWS_SHARED_CREDENTIALS_FILE="+v)
+	}
+
+	configFile := ""
+	if v, ok := confMap["aws_config_file"].(string); ok && v != "" {
+		configFile = v
+		sessionConf.pluginEnv = append(sessionConf.pluginEnv, "AWS_CONFIG_FILE="+v)
+	}
+
+	var sharedConfigFiles []string
+	if credentialsFile != "" || configFile != "" {
+		if configFile == "" {
+			configFile = defaults.SharedConfigFilename()
+		}
+		if credentialsFile == "" {
+			credentialsFile = defaults.SharedCredentialsFilename()
+		}
+		// Same order as the SDK's default: credentials override config.
+		sharedConfigFiles = []string{configFile, credentialsFile}
+	}
+
+	// The SDK reads a CA bundle given through AWS_CA_BUNDLE on its own, so
+	// only the explicit setting is handled here.
+	var caBundle io.Reader
+	if v, ok := confMap["aws_ca_bundle"].(string); ok && v != "" {
+		b, err := os.ReadFile(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("aws_ca_bundle: %w", err)
+		}
+		caBundle = bytes.NewReader(b)
+		sessionConf.pluginEnv = append(sessionConf.pluginEnv, "AWS_CA_BUNDLE="+v)
+	}
+
+	awsConfig := aws.Config{Region: aws.String(region)}
+	if v, ok := confMap["aws_partition"].(string); ok && v != "" {
+		resolver, err := partitionResolver(v, region)
+		if err != nil {
+			return nil, nil, err
+		}
+		awsConfig.EndpointResolver = resolver
+	}
+	// Applies to every client of the session, so that the EC2, RDS and STS
+	// calls stay within FIPS endpoints as well. aws_ssm_endpoint_url still
+	// takes precedence for SSM.
+	if v, ok := confMap["use_fips_endpoint"].(bool); ok && v {
+		awsConfig.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+
+	var err error
+	sessionConf.session, err = session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable, // Must be set to enable
-		Profile:           profile,
-		Config:            aws.Config{Region: aws.String(region)},
+		SharedConfigFiles: sharedConfigFiles,
+		Profile:           sessionConf.profile,
+		Config:            awsConfig,
+		CustomCABundle:    caBundle,
 	})
+	if err != nil && caBundle != nil {
+		return nil, nil, fmt.Errorf("aws_ca_bundle: %w", err)
+	}
+
+	if v, ok := confMap["assume_role_arn"].(string); ok && v != "" && sessionConf.session != nil {
+		sessionName, _ := confMap["assume_role_session_name"].(string)
+		duration, _ := confMap["assume_role_duration_sec"].(int)
+		creds := assumeRoleCredentials(sts.New(sessionConf.session), v, sessionName, time.Duration(duration)*time.Second)
+		sessionConf.session = sessionConf.session.Copy(&aws.Config{Credentials: creds})
+	}
+
+	if v, ok := confMap["ec2_instance_tag"].(string); ok && v != "" && sessionConf.session != nil {
+		instanceID, err := resolveInstanceByTag(ec2.New(sessionConf.session), v)
+		if err != nil {
+			return nil, nil, err
+		}
+		sessionConf.instanceID = instanceID
+	}
 
 	if err := sessionConf.validate(); err != nil {
 		return nil, nil, err
 	}
+	pfConf["remote_endpoint"] = fmt.Sprintf("%s:22", sessionConf.instanceID)
 
 	if v, ok := confMap["rds_endpoint"].(string); ok && v != "" {
 		pfConf["db_endpoint"] = v
 	}
 
+	if v, ok := confMap["rds_identifier"].(string); ok && v != "" {
+		endpoint, err := resolveRDSEndpoint(rds.New(sessionConf.session), v)
+		if err != nil {
+			return nil, nil, err
+		}
+		pfConf["db_endpoint"] = endpoint
+	}
+	setDBHostPort(pfConf, confMap)
+
 	if v, ok := confMap["use_remote_port_forward"].(bool); ok {
 		pfConf["use_remote_port_forward"] = strconv.FormatBool(v)
 	}
 
+	if v, ok := confMap["verify_handshake"].(bool); ok {
+		pfConf["verify_handshake"] = strconv.FormatBool(v)
+	}
+
+	if v, ok := confMap["auto_reconnect"].(bool); ok {
+		pfConf["auto_reconnect"] = strconv.FormatBool(v)
+	}
+
+	if v, ok := confMap["local_port"].(int); ok && v > 0 {
+		pfConf["local_port"] = strconv.Itoa(v)
+	}
+
+	if v, ok := confMap["local_bind_address"].(string); ok && v != "" {
+		pfConf["local_bind_address"] = v
+	}
+
+	if v, ok := confMap["socks_local_port"].(int); ok && v > 0 {
+		pfConf["socks_local_port"] = strconv.Itoa(v)
+	}
+
+	setAdditionalForwards(pfConf, confMap)
+
 	if pfConf["use_remote_port_forward"] == "false" {
 		cu, _ := user.Current()
 		pfConf["ssh_user"] = cu.Username
@@ -76,31 +220,98 @@ func ParseSessionConfig(d *schema.ResourceData) (*sessionConfig, map[string]stri
 			pfConf["ssh_user"] = v
 		}
 
-		pfConf["ssh_key_path"] = defaultSSHKeyPath()
-		if v, ok := confMap["ssh_key_path"].(string); ok && v != "" {
-			pfConf["ssh_key_path"] = v
+		if v, ok := confMap["ssh_private_key"].(string); ok && v != "" {
+			pfConf["ssh_private_key"] = v
+		} else {
+			setSSHKeyPaths(pfConf, confMap)
+		}
+		setSSHAuth(pfConf, confMap)
+
+		if v, ok := confMap["disable_known_hosts"].(bool); ok {
+			pfConf["disable_known_hosts"] = strconv.FormatBool(v)
+		}
+
+		if v, ok := confMap["known_hosts_scope"].(string); ok && v != "" {
+			pfConf["known_hosts_scope"] = v
+		}
+
+		if v, ok := confMap["ssh_host_public_key"].(string); ok && v != "" {
+			pfConf["ssh_host_public_key"] = v
+		}
+
+		if v, ok := confMap["ssh_next_hop"].([]interface{}); ok && len(v) > 0 && v[0] != nil {
+			hop := v[0].(map[string]interface{})
+			for _, k := range []string{"host", "ssh_user", "ssh_key_path", "ssh_private_key", "ssh_host_public_key"} {
+				if v, ok := hop[k].(string); ok && v != "" {
+					pfConf["next_hop_"+k] = v
+				}
+			}
+		}
+
+		if v, ok := confMap["inner_dial_timeout_sec"].(int); ok && v > 0 {
+			pfConf["inner_dial_timeout_sec"] = strconv.Itoa(v)
 		}
 	}
 
+	// Copied in either mode, so that ParsePFConfig rejects it for remote
+	// port forwarding instead of it being dropped.
+	if v, ok := confMap["send_proxy_protocol"].(string); ok && v != "" {
+		pfConf["send_proxy_protocol"] = v
+	}
+
 	return sessionConf, pfConf, nil
 
 }
 
-func Connect(sessConf *sessionConfig, pfConf *portFowardConfig) error {
+// Connect opens the tunnel. It stays up until ctx is canceled or Cleanup is
+// called, which cancels it as well.
+func Connect(ctx context.Context, sessConf *sessionConfig, pfConf *portFowardConfig) error {
 	if pfConf == nil {
 		return nil
 	}
+
+	if pfConf.keepOpen && (sessConf == nil || !pfConf.useRemotePortForward) {
+		logf(logWarn, "keep_tunnel_open only applies with use_remote_port_forward; the tunnel over SSH on %s closes with the provider", pfConf.LocalAddr())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	start := time.Now()
+	var err error
 	if sessConf == nil {
-		return pfConf.Connect()
+		err = pfConf.Connect(ctx)
+	} else {
+		err = sessConf.connect(ctx, pfConf)
+	}
+	if err != nil {
+		cancel()
+		return err
 	}
-	return sessConf.connect(pfConf)
+	// Registered last so that Cleanup cancels before closing anything, and
+	// a dropped connection isn't mistaken for one to reconnect.
+	registerCleanup(func() error {
+		cancel()
+		return nil
+	})
+	logDuration("setup", start)
+
+	start = time.Now()
+	if err := pfConf.waitStartup(); err != nil {
+		return err
+	}
+	if err := pfConf.VerifyHandshake(); err != nil {
+		return err
+	}
+	logDuration("readiness check", start)
+
+	return nil
 }
 
 func (conf *sessionConfig) validate() error {
 
 	var errors error
 	if conf.instanceID == "" {
-		errors = multierror.Append(errors, fmt.Errorf("not set ec2_instance_id"))
+		errors = multierror.Append(errors, fmt.Errorf("not set ec2_instance_id or ec2_instance_tag"))
 	}
 	if conf.session == nil {
 		errors = multierror.Append(errors, fmt.Errorf("AWS configure is not a valid"))
@@ -113,95 +324,313 @@ func (conf *sessionConfig) validate() error {
 	return nil
 }
 
-func (conf *sessionConfig) connect(pfConf *portFowardConfig) error {
-	var proxyCmd *exec.Cmd
-	var closeSession func() error
-	var err error
+// ssmClient returns an SSM client honoring the configured endpoint override.
+// The resolved endpoint is also what gets handed to session-manager-plugin.
+func (conf *sessionConfig) ssmClient() *ssm.SSM {
+	if conf.ssmEndpoint == "" {
+		return ssm.New(conf.session)
+	}
+	return ssm.New(conf.session, &aws.Config{Endpoint: aws.String(conf.ssmEndpoint)})
+}
+
+// setPluginEnv passes explicitly configured AWS file locations on to the
+// session-manager-plugin subprocess.
+func (conf *sessionConfig) setPluginEnv(cmd *exec.Cmd) {
+	if len(conf.pluginEnv) == 0 {
+		return
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, conf.pluginEnv...)
+}
+
+// assumeRoleCredentials returns credentials for roleARN that are assumed
+// again shortly before they expire, so that SSM calls keep working during
+// applies longer than the session duration.
+func assumeRoleCredentials(client stscreds.AssumeRoler, roleARN, sessionName string, duration time.Duration) *credentials.Credentials {
+	return stscreds.NewCredentialsWithClient(client, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		if sessionName != "" {
+			p.RoleSessionName = sessionName
+		}
+		if duration > 0 {
+			p.Duration = duration
+		}
+		p.ExpiryWindow = assumeRoleExpiryWindow
+	})
+}
+
+// pluginProfile returns the profile handed to session-manager-plugin.
+// Credentials coming from a credential_process, from IAM Identity Center
+// (AWS SSO) or from an assumed role are resolved here and exported to the
+// plugin instead, so that it does not depend on loading the shared config and
+// the SSO token cache the same way the SDK session does. The plugin only needs
+// them while the session starts, the data channel is authenticated by the
+// session token afterwards, so their expiry doesn't drop the tunnel.
+func (conf *sessionConfig) pluginProfile() string {
+	creds, err := conf.session.Config.Credentials.Get()
+	if err == nil && (creds.ProviderName == processcreds.ProviderName ||
+		creds.ProviderName == ssocreds.ProviderName ||
+		creds.ProviderName == stscreds.ProviderName) {
+		conf.pluginEnv = append(conf.pluginEnv,
+			"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+			"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+			"AWS_SESSION_TOKEN="+creds.SessionToken,
+		)
+		return ""
+	}
+
+	if conf.profile != "" {
+		return conf.profile
+	}
+	return getAWSProfile()
+}
 
+func (conf *sessionConfig) connect(ctx context.Context, pfConf *portFowardConfig) error {
 	if pfConf.useRemotePortForward {
-		proxyCmd, closeSession, err = openRemotePortForwardSession(ssm.New(conf.session), conf.instanceID, pfConf.dbEndpoint, pfConf.localPort)
+		return conf.serveRemotePortForward(ctx, pfConf)
+	}
+
+	return pfConf.serve(ctx, func(ctx context.Context) (*ssh.Client, func() error, error) {
+		start := time.Now()
+		proxyCmd, closeSession, err := openSession(conf.ssmClient(), conf.pluginProfile(), conf.instanceID, conf.documentName)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
+		logDuration("StartSession", start)
+		conf.setPluginEnv(proxyCmd)
 
-		if err := proxyCmd.Start(); err != nil {
-			return err
+		sshConfig, err := pfConf.CreateSSHClientConfig()
+		if err != nil {
+			var errors error = err
+
+			if err := closeSession(); err != nil {
+				errors = multierror.Append(errors, err)
+			}
+			return nil, nil, errors
 		}
 
-		go func() {
-			proxyCmd.Wait()
-		}()
-		return nil
-	}
-	proxyCmd, closeSession, err = openSession(ssm.New(conf.session), conf.instanceID)
+		start = time.Now()
+		sshClient, killProxyCmd, err := pfConf.CreateSSHClientWithProxyCommand(proxyCmd, sshConfig)
+		if err != nil {
+			var errors error = err
+
+			if err := closeSession(); err != nil {
+				errors = multierror.Append(errors, err)
+			}
+			return nil, nil, errors
+		}
+		logDuration("SSH handshake through session-manager-plugin", start)
+
+		return sshClient, func() error {
+			var errs error
+			if err := sshClient.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				errs = multierror.Append(errs, err)
+			}
+			if err := killProxyCmd(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+				errs = multierror.Append(errs, err)
+			}
+			if err := closeSession(); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			return errs
+		}, nil
+	})
+}
+
+// serveRemotePortForward runs session-manager-plugin, which listens on the
+// local port itself. With auto_reconnect, a plugin that exits is replaced by
+// one for a new session on the same port.
+func (conf *sessionConfig) serveRemotePortForward(ctx context.Context, pfConf *portFowardConfig) error {
+	proxyCmd, stop, err := conf.startRemotePortForward(pfConf)
 	if err != nil {
 		return err
 	}
+	current := &currentConn{close: stop}
+	if pfConf.keepOpen {
+		// session-manager-plugin is a process of its own, which keeps the
+		// port bound once the provider is gone.
+		logf(logWarn, "keep_tunnel_open: the tunnel on %s stays open after the provider exits and has to be closed by hand: "+
+			"kill %d; the SSM session is terminated by the next run of the provider or with aws ssm terminate-session",
+			pfConf.LocalAddr(), proxyCmd.Process.Pid)
+	} else {
+		registerCleanup(current.Close)
+	}
+
+	go func() {
+		for {
+			err := proxyCmd.Wait()
+			if !pfConf.autoReconnect || ctx.Err() != nil || current.closing() {
+				pfConf.fail(fmt.Errorf("session-manager-plugin exited: %v", err))
+				return
+			}
+
+			logf(logWarn, "session-manager-plugin exited, reconnecting: %v", err)
+			current.drop()
+			err = retryReconnect(ctx, pfConf.connectRetryInterval, func() error {
+				var err error
+				proxyCmd, stop, err = conf.startRemotePortForward(pfConf)
+				return err
+			})
+			if err != nil || !current.replace(stop) {
+				return
+			}
+		}
+	}()
 
-	sshConfig, err := pfConf.CreateSSHClientConfig()
+	return nil
+}
+
+// startRemotePortForward starts a port forwarding session and the plugin
+// serving it, and returns the plugin along with a function that stops it and
+// terminates the session.
+func (conf *sessionConfig) startRemotePortForward(pfConf *portFowardConfig) (*exec.Cmd, func() error, error) {
+	start := time.Now()
+	proxyCmd, closeSession, err := openRemotePortForwardSession(conf.ssmClient(), conf.pluginProfile(), conf.instanceID, conf.documentName, pfConf.dbEndpoint, pfConf.localPort)
 	if err != nil {
-		errors := err
+		return nil, nil, err
+	}
+	logDuration("StartSession", start)
+	conf.setPluginEnv(proxyCmd)
+
+	start = time.Now()
+	if err := proxyCmd.Start(); err != nil {
+		var errors error = err
 
 		if err := closeSession(); err != nil {
-			errors = multierror.Append(err)
+			errors = multierror.Append(errors, err)
 		}
-		closeSession()
-		return errors
+		return nil, nil, errors
 	}
+	logDuration("session-manager-plugin launch", start)
 
-	sshClient, killProxyCmd, err := pfConf.CreateSSHClientWithProxyCommand(proxyCmd, sshConfig)
-	if err != nil {
-		errors := err
-
+	return proxyCmd, sync.OnceValue(func() error {
+		var errs error
+		if err := proxyCmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			errs = multierror.Append(errs, err)
+		}
 		if err := closeSession(); err != nil {
-			errors = multierror.Append(err)
+			errs = multierror.Append(errs, err)
 		}
-		closeSession()
-		return errors
+		return errs
+	}), nil
+}
+
+// partitionResolver resolves endpoints within the given partition only. The
+// SDK already derives the partition from the region, including regions it
+// doesn't know yet but whose name matches a partition; this is for regions
+// that don't. Session-manager-plugin is handed the endpoint resolved here.
+func partitionResolver(partitionID string, region string) (endpoints.Resolver, error) {
+	var partition endpoints.Partition
+	found := false
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() == partitionID {
+			partition, found = p, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("aws_partition: unknown partition %s", partitionID)
 	}
 
-	if err := pfConf.PortForward(sshClient); err != nil {
-		errors := err
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); region != "" && ok && p.ID() != partitionID {
+		return nil, fmt.Errorf("aws_partition: region %s belongs to partition %s, not %s", region, p.ID(), partitionID)
+	}
 
-		if err := sshClient.Close(); err != nil {
-			errors = multierror.Append(err)
-		}
-		if err := killProxyCmd(); err != nil {
-			errors = multierror.Append(err)
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		return partition.EndpointFor(service, region, opts...)
+	}), nil
+}
+
+// resolveInstanceByTag finds the running EC2 instance carrying the given
+// "key=value" tag. Exactly one instance has to match.
+func resolveInstanceByTag(svc *ec2.EC2, tag string) (string, error) {
+	key, value, ok := strings.Cut(tag, "=")
+	if !ok || key == "" {
+		return "", fmt.Errorf("ec2_instance_tag: %q is not in key=value format", tag)
+	}
+
+	var instanceIDs []string
+	err := svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:" + key), Values: []*string{aws.String(value)}},
+			{Name: aws.String("instance-state-name"), Values: []*string{aws.String(ec2.InstanceStateNameRunning)}},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				instanceIDs = append(instanceIDs, aws.StringValue(instance.InstanceId))
+			}
 		}
-		if err := closeSession(); err != nil {
-			errors = multierror.Append(err)
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("ec2_instance_tag: describing instances tagged %s: %w", tag, err)
+	}
+
+	switch len(instanceIDs) {
+	case 0:
+		return "", fmt.Errorf("ec2_instance_tag: no running instance tagged %s", tag)
+	case 1:
+		return instanceIDs[0], nil
+	default:
+		return "", fmt.Errorf("ec2_instance_tag: %d running instances tagged %s (%s), expected exactly one", len(instanceIDs), tag, strings.Join(instanceIDs, ", "))
+	}
+}
+
+// resolveRDSEndpoint looks up the endpoint of an RDS DB instance, falling back
+// to an Aurora DB cluster with the same identifier.
+func resolveRDSEndpoint(svc *rds.RDS, identifier string) (string, error) {
+	instances, err := svc.DescribeDBInstances(&rds.DescribeDBInstancesInput{
+		DBInstanceIdentifier: aws.String(identifier),
+	})
+	if err == nil {
+		for _, instance := range instances.DBInstances {
+			if instance.Endpoint != nil && instance.Endpoint.Address != nil {
+				return fmt.Sprintf("%s:%d", aws.StringValue(instance.Endpoint.Address), aws.Int64Value(instance.Endpoint.Port)), nil
+			}
 		}
-		return errors
+		return "", fmt.Errorf("rds_identifier: DB instance %s has no endpoint yet", identifier)
 	}
 
-	return nil
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != rds.ErrCodeDBInstanceNotFoundFault {
+		return "", fmt.Errorf("rds_identifier: describing DB instance %s: %w", identifier, err)
+	}
+
+	clusters, err := svc.DescribeDBClusters(&rds.DescribeDBClustersInput{
+		DBClusterIdentifier: aws.String(identifier),
+	})
+	if err != nil {
+		return "", fmt.Errorf("rds_identifier: %s is neither a DB instance nor a DB cluster: %w", identifier, err)
+	}
+	for _, cluster := range clusters.DBClusters {
+		if cluster.Endpoint != nil {
+			return fmt.Sprintf("%s:%d", aws.StringValue(cluster.Endpoint), aws.Int64Value(cluster.Port)), nil
+		}
+	}
+	return "", fmt.Errorf("rds_identifier: DB cluster %s has no endpoint yet", identifier)
 }
 
-func openSession(svc *ssm.SSM, instanceID string) (*exec.Cmd, func() error, error) {
+func openSession(svc *ssm.SSM, profile string, instanceID string, documentName string) (*exec.Cmd, func() error, error) {
+	if documentName == "" {
+		documentName = defaultSSHDocumentName
+	}
+
 	in := &ssm.StartSessionInput{
-		DocumentName: aws.String("AWS-StartSSHSession"),
+		DocumentName: aws.String(documentName),
 		Parameters: map[string][]*string{
 			"portNumber": {aws.String("22")},
 		},
 		Target: aws.String(instanceID),
 	}
-	out, err := svc.StartSession(in)
+	out, err := startSession(svc, in)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	close := func() error {
-		in := &ssm.TerminateSessionInput{
-			SessionId: out.SessionId,
-		}
-		if _, err := svc.TerminateSession(in); err != nil {
-			return err
-		}
-		return nil
-	}
+	close := terminateSessionFunc(svc, out.SessionId)
 
-	cmd, err := sessionManagerPlugin(svc, in, out)
+	cmd, err := sessionManagerPlugin(svc, profile, in, out)
 	if err != nil {
 		defer close()
 		return nil, nil, err
@@ -210,18 +639,15 @@ func openSession(svc *ssm.SSM, instanceID string) (*exec.Cmd, func() error, erro
 	return cmd, close, nil
 }
 
-func openRemotePortForwardSession(svc *ssm.SSM, instanceID string, rdsEndpoint string, localPort uint16) (*exec.Cmd, func() error, error) {
-	host := rdsEndpoint
-	port := "3306"
-
-	if strings.Contains(rdsEndpoint, ":") {
-		split := strings.Split(rdsEndpoint, ":")
-		host = split[0]
-		port = split[1]
+func openRemotePortForwardSession(svc *ssm.SSM, profile string, instanceID string, documentName string, rdsEndpoint string, localPort uint16) (*exec.Cmd, func() error, error) {
+	if documentName == "" {
+		documentName = defaultPortForwardDocumentName
 	}
 
+	host, port := splitDBEndpoint(rdsEndpoint)
+
 	in := &ssm.StartSessionInput{
-		DocumentName: aws.String("AWS-StartPortForwardingSessionToRemoteHost"),
+		DocumentName: aws.String(documentName),
 		Parameters: map[string][]*string{
 			"host":            {aws.String(host)},
 			"portNumber":      {aws.String(port)},
@@ -230,22 +656,14 @@ func openRemotePortForwardSession(svc *ssm.SSM, instanceID string, rdsEndpoint s
 		Target: aws.String(instanceID),
 	}
 
-	out, err := svc.StartSession(in)
+	out, err := startSession(svc, in)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	close := func() error {
-		in := &ssm.TerminateSessionInput{
-			SessionId: out.SessionId,
-		}
-		if _, err := svc.TerminateSession(in); err != nil {
-			return err
-		}
-		return nil
-	}
+	close := terminateSessionFunc(svc, out.SessionId)
 
-	cmd, err := sessionManagerPlugin(svc, in, out)
+	cmd, err := sessionManagerPlugin(svc, profile, in, out)
 	if err != nil {
 		defer close()
 		return nil, nil, err
@@ -254,8 +672,80 @@ func openRemotePortForwardSession(svc *ssm.SSM, instanceID string, rdsEndpoint s
 	return cmd, close, nil
 }
 
+// startSession terminates sessions to the same target left behind by earlier
+// runs before starting a new one, and records the new session so that a later
+// run can do the same for it.
+func startSession(svc *ssm.SSM, in *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	target := aws.StringValue(in.Target)
+	terminateStaleSessions(target, func(sessionID string) error {
+		_, err := svc.TerminateSession(&ssm.TerminateSessionInput{SessionId: aws.String(sessionID)})
+		return err
+	})
+
+	out, err := svc.StartSession(in)
+	if err != nil {
+		if isSessionLimitError(err) {
+			err = fmt.Errorf("starting SSM session to %s: %w\nAWS rejected the session because too many sessions are open. "+
+				"Terminate stale sessions in the Session Manager console or with `aws ssm terminate-session` and try again", target, err)
+		}
+		return nil, withPhase(ErrSSMStartSession, err)
+	}
+
+	trackSession(target, aws.StringValue(out.SessionId))
+	return out, nil
+}
+
+// splitDBEndpoint splits a DB endpoint into host and port. The port defaults
+// to 3306 only when the endpoint does not carry one, so that e.g. the X
+// Protocol port 33060 can be forwarded as well.
+func splitDBEndpoint(endpoint string) (string, string) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(endpoint, "["), "]"), "3306"
+	}
+	return host, port
+}
+
+// setDBHostPort sets db_endpoint from db_host and db_port, which name the
+// target without having to join host and port into one string, e.g. for an
+// IPv6 address. db_port on its own replaces the port of the endpoint.
+func setDBHostPort(pfConf map[string]string, confMap map[string]interface{}) {
+	if v, ok := confMap["db_host"].(string); ok && v != "" {
+		pfConf["db_endpoint"] = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(v, "["), "]"), "3306")
+	}
+	if v, ok := confMap["db_port"].(int); ok && v > 0 && pfConf["db_endpoint"] != "" {
+		host, _ := splitDBEndpoint(pfConf["db_endpoint"])
+		pfConf["db_endpoint"] = net.JoinHostPort(host, strconv.Itoa(v))
+	}
+}
+
+// dbEndpointAddr returns endpoint as host:port, with the port defaulting like
+// in splitDBEndpoint, for dialing it through SSH. The port is the one the
+// database listens on, regardless of the local port of the tunnel.
+func dbEndpointAddr(endpoint string) string {
+	return net.JoinHostPort(splitDBEndpoint(endpoint))
+}
+
+// terminateSessionFunc returns a callback that terminates the given SSM
+// session. Failures are logged here so that they stay visible even when the
+// caller is already returning a different error.
+func terminateSessionFunc(svc *ssm.SSM, sessionID *string) func() error {
+	return func() error {
+		in := &ssm.TerminateSessionInput{
+			SessionId: sessionID,
+		}
+		if _, err := svc.TerminateSession(in); err != nil {
+			logf(logWarn, "failed to terminate SSM session %s: %s", aws.StringValue(sessionID), err)
+			return err
+		}
+		untrackSession(aws.StringValue(sessionID))
+		return nil
+	}
+}
+
 func sessionManagerPlugin(
 	svc *ssm.SSM,
+	profile string,
 	in *ssm.StartSessionInput,
 	out *ssm.StartSessionOutput,
 ) (*exec.Cmd, error) {
@@ -273,14 +763,74 @@ func sessionManagerPlugin(
 		return nil, err
 	}
 	region := *svc.Config.Region
-	profile := getAWSProfile()
 	endpoint := svc.Endpoint
 
-	cmd := exec.Command(command, string(encodedOut), region, "StartSession", profile, string(encodedIn), endpoint)
+	v, err := sessionManagerPluginVersion(command)
+	if err != nil {
+		return nil, err
+	}
+
+	args, env := sessionManagerPluginArgs(v, string(encodedOut), region, profile, string(encodedIn), endpoint)
+	cmd := exec.Command(command, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	return cmd, nil
 }
 
+// minSessionManagerPluginVersion is the oldest session-manager-plugin known to
+// accept the arguments built by sessionManagerPluginArgs. Older releases fail
+// with errors that don't point at the version.
+var minSessionManagerPluginVersion = version.Must(version.NewVersion("1.2.0.0"))
+
+// envResponseSessionManagerPluginVersion is the first release that reads the
+// StartSession response from the environment variable named in place of it,
+// which is what the AWS CLI uses to keep the session token out of the
+// process list.
+var envResponseSessionManagerPluginVersion = version.Must(version.NewVersion("1.2.497.0"))
+
+const sessionManagerPluginResponseEnv = "AWS_SSM_START_SESSION_RESPONSE"
+
+// sessionManagerPluginVersion returns the version of the installed plugin.
+// A plugin too old to be used is reported with what to do about it; a version
+// that can't be determined is not, and nil is returned for it instead.
+func sessionManagerPluginVersion(command string) (*version.Version, error) {
+	out, err := exec.Command(command, "--version").Output()
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s not found in PATH: install the Session Manager plugin, see "+
+			"https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html", command)
+	}
+	if err != nil {
+		logf(logWarn, "could not determine the version of %s: %s", command, err)
+		return nil, nil
+	}
+
+	v, err := version.NewVersion(strings.TrimSpace(string(out)))
+	if err != nil {
+		logf(logWarn, "could not determine the version of %s from %q", command, out)
+		return nil, nil
+	}
+
+	if v.LessThan(minSessionManagerPluginVersion) {
+		return nil, fmt.Errorf("%s %s is not supported, version %s or later is required: update the Session Manager plugin, see "+
+			"https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html",
+			command, v, minSessionManagerPluginVersion)
+	}
+	return v, nil
+}
+
+// sessionManagerPluginArgs returns the arguments and extra environment for the
+// plugin. Releases that support it get the StartSession response through the
+// environment; others, and plugins of unknown version, as an argument.
+func sessionManagerPluginArgs(v *version.Version, response, region, profile, request, endpoint string) ([]string, []string) {
+	if v != nil && !v.LessThan(envResponseSessionManagerPluginVersion) {
+		return []string{sessionManagerPluginResponseEnv, region, "StartSession", profile, request, endpoint},
+			[]string{sessionManagerPluginResponseEnv + "=" + response}
+	}
+	return []string{response, region, "StartSession", profile, request, endpoint}, nil
+}
+
 func getAWSProfile() string {
 	profile := os.Getenv("AWS_PROFILE")
 	if profile != "" {
@@ -0,0 +1,412 @@
+package port_forward
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func testSessionConfigData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	return schema.TestResourceDataRaw(t, map[string]*schema.Schema{
+		"aws_ssm_session_manager_client_config": {
+			Type:     schema.TypeList,
+			Optional: true,
+			MaxItems: 1,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"ec2_instance_id":         {Type: schema.TypeString, Optional: true},
+					"rds_endpoint":            {Type: schema.TypeString, Optional: true},
+					"region":                  {Type: schema.TypeString, Optional: true},
+					"aws_partition":           {Type: schema.TypeString, Optional: true},
+					"use_fips_endpoint":       {Type: schema.TypeBool, Optional: true},
+					"aws_ssm_endpoint_url":    {Type: schema.TypeString, Optional: true},
+					"aws_ca_bundle":           {Type: schema.TypeString, Optional: true},
+					"aws_profile":             {Type: schema.TypeString, Optional: true},
+					"aws_config_file":         {Type: schema.TypeString, Optional: true},
+					"use_remote_port_forward": {Type: schema.TypeBool, Optional: true, Default: true},
+					"ssh_user":                {Type: schema.TypeString, Optional: true},
+					"ssh_key_path":            {Type: schema.TypeString, Optional: true},
+				},
+			},
+		},
+	}, map[string]interface{}{
+		"aws_ssm_session_manager_client_config": []interface{}{raw},
+	})
+}
+
+func TestParseSessionConfig_remotePortForwardWithoutSSH(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	d := testSessionConfigData(t, map[string]interface{}{
+		"ec2_instance_id": "i-0123456789abcdef0",
+		"rds_endpoint":    "db.example.com:3306",
+		"region":          "ap-northeast-1",
+	})
+
+	sessConf, confMap, err := ParseSessionConfig(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if sessConf.instanceID != "i-0123456789abcdef0" {
+		t.Fatalf("unexpected instance ID %q", sessConf.instanceID)
+	}
+
+	pfConf, err := ParsePFConfig(confMap, 3306)
+	if err != nil {
+		t.Fatalf("remote port forwarding must not require SSH credentials: %s", err)
+	}
+	if !pfConf.useRemotePortForward {
+		t.Fatal("expected remote port forwarding")
+	}
+}
+
+func TestParseSessionConfig_sshRequiresKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	d := testSessionConfigData(t, map[string]interface{}{
+		"ec2_instance_id":         "i-0123456789abcdef0",
+		"rds_endpoint":            "db.example.com:3306",
+		"region":                  "ap-northeast-1",
+		"use_remote_port_forward": false,
+		"ssh_user":                "ec2-user",
+	})
+
+	_, confMap, err := ParseSessionConfig(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := ParsePFConfig(confMap, 3306); err == nil {
+		t.Fatal("expected an error for a missing SSH key")
+	}
+}
+
+func TestParseSessionConfig_partition(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	cases := []struct {
+		region    string
+		partition string
+		endpoint  string
+	}{
+		{"cn-north-1", "", "https://ssm.cn-north-1.amazonaws.com.cn"},
+		{"us-gov-west-1", "", "https://ssm.us-gov-west-1.amazonaws.com"},
+		{"cn-north-1", "aws-cn", "https://ssm.cn-north-1.amazonaws.com.cn"},
+		{"xx-china-1", "aws-cn", "https://ssm.xx-china-1.amazonaws.com.cn"},
+	}
+
+	for _, c := range cases {
+		d := testSessionConfigData(t, map[string]interface{}{
+			"ec2_instance_id": "i-0123456789abcdef0",
+			"rds_endpoint":    "db.example.com:3306",
+			"region":          c.region,
+			"aws_partition":   c.partition,
+		})
+
+		sessConf, _, err := ParseSessionConfig(d)
+		if err != nil {
+			t.Fatalf("%s/%s: unexpected error: %s", c.region, c.partition, err)
+		}
+		if got := sessConf.ssmClient().Endpoint; got != c.endpoint {
+			t.Errorf("%s/%s: got %s, want %s", c.region, c.partition, got, c.endpoint)
+		}
+	}
+
+	d := testSessionConfigData(t, map[string]interface{}{
+		"ec2_instance_id": "i-0123456789abcdef0",
+		"rds_endpoint":    "db.example.com:3306",
+		"region":          "us-east-1",
+		"aws_partition":   "aws-cn",
+	})
+	if _, _, err := ParseSessionConfig(d); err == nil {
+		t.Fatal("expected an error for a region outside the partition")
+	}
+}
+
+func TestParseSessionConfig_fipsEndpoint(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	cases := []struct {
+		region   string
+		override string
+		endpoint string
+	}{
+		{"us-east-1", "", "https://ssm-fips.us-east-1.amazonaws.com"},
+		{"us-gov-west-1", "", "https://ssm.us-gov-west-1.amazonaws.com"},
+		{"us-east-1", "https://ssm.example.com", "https://ssm.example.com"},
+	}
+
+	for _, c := range cases {
+		d := testSessionConfigData(t, map[string]interface{}{
+			"ec2_instance_id":      "i-0123456789abcdef0",
+			"rds_endpoint":         "db.example.com:3306",
+			"region":               c.region,
+			"use_fips_endpoint":    true,
+			"aws_ssm_endpoint_url": c.override,
+		})
+
+		sessConf, _, err := ParseSessionConfig(d)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.region, err)
+		}
+		svc := sessConf.ssmClient()
+		if svc.Endpoint != c.endpoint {
+			t.Errorf("%s: got %s, want %s", c.region, svc.Endpoint, c.endpoint)
+		}
+		if got := aws.StringValue(svc.Config.Region); got != c.region {
+			t.Errorf("%s: got region %s", c.region, got)
+		}
+	}
+}
+
+func TestParseSessionConfig_caBundle(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Corporate Proxy CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := testSessionConfigData(t, map[string]interface{}{
+		"ec2_instance_id": "i-0123456789abcdef0",
+		"rds_endpoint":    "db.example.com:3306",
+		"region":          "ap-northeast-1",
+		"aws_ca_bundle":   bundle,
+	})
+	sessConf, _, err := ParseSessionConfig(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fmt.Sprint(sessConf.pluginEnv) != fmt.Sprint([]string{"AWS_CA_BUNDLE=" + bundle}) {
+		t.Fatalf("expected AWS_CA_BUNDLE to be passed to the plugin, got %v", sessConf.pluginEnv)
+	}
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d = testSessionConfigData(t, map[string]interface{}{
+		"ec2_instance_id": "i-0123456789abcdef0",
+		"rds_endpoint":    "db.example.com:3306",
+		"region":          "ap-northeast-1",
+		"aws_ca_bundle":   invalid,
+	})
+	if _, _, err := ParseSessionConfig(d); err == nil {
+		t.Fatal("expected an error for an invalid CA bundle")
+	}
+}
+
+func TestParseSessionConfig_ssoSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	config := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(config, []byte(`[profile dev]
+sso_session = corp
+sso_account_id = 123456789012
+sso_role_name = DatabaseAdmin
+region = ap-northeast-1
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = ap-northeast-1
+sso_registration_scopes = sso:account:access
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := testSessionConfigData(t, map[string]interface{}{
+		"ec2_instance_id": "i-0123456789abcdef0",
+		"rds_endpoint":    "db.example.com:3306",
+		"aws_profile":     "dev",
+		"aws_config_file": config,
+	})
+	sessConf, _, err := ParseSessionConfig(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if region := *sessConf.session.Config.Region; region != "ap-northeast-1" {
+		t.Fatalf("expected the region of the SSO profile, got %q", region)
+	}
+
+	// Without a cached token from `aws sso login` the credentials can't be
+	// resolved, so the plugin is pointed at the profile instead.
+	if profile := sessConf.pluginProfile(); profile != "dev" {
+		t.Fatalf("expected the profile to be passed to the plugin, got %q", profile)
+	}
+}
+
+type fakeAssumeRoler struct {
+	calls    int
+	lifetime time.Duration
+	input    *sts.AssumeRoleInput
+}
+
+func (f *fakeAssumeRoler) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	f.calls++
+	f.input = input
+	return &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(fmt.Sprintf("AKID%d", f.calls)),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(f.lifetime)),
+		},
+	}, nil
+}
+
+func TestAssumeRoleCredentials_refresh(t *testing.T) {
+	// Credentials within the expiry window are assumed again on the next use.
+	client := &fakeAssumeRoler{lifetime: assumeRoleExpiryWindow - time.Second}
+	creds := assumeRoleCredentials(client, "arn:aws:iam::123456789012:role/db", "terraform", time.Hour)
+
+	first, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 2 || first.AccessKeyID == second.AccessKeyID {
+		t.Fatalf("expected the credentials to be refreshed, got %d AssumeRole calls", client.calls)
+	}
+	if *client.input.RoleSessionName != "terraform" || *client.input.DurationSeconds != 3600 {
+		t.Fatalf("unexpected AssumeRole input: %s", client.input)
+	}
+
+	client = &fakeAssumeRoler{lifetime: time.Hour}
+	creds = assumeRoleCredentials(client, "arn:aws:iam::123456789012:role/db", "", 0)
+	for i := 0; i < 2; i++ {
+		if _, err := creds.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.calls != 1 {
+		t.Fatalf("expected valid credentials to be reused, got %d AssumeRole calls", client.calls)
+	}
+}
+
+func TestSetDBHostPort(t *testing.T) {
+	cases := []struct {
+		confMap map[string]interface{}
+		want    string
+	}{
+		{map[string]interface{}{"db_host": "db.example.com"}, "db.example.com:3306"},
+		{map[string]interface{}{"db_host": "db.example.com", "db_port": 3307}, "db.example.com:3307"},
+		{map[string]interface{}{"db_host": "2001:db8::1", "db_port": 3307}, "[2001:db8::1]:3307"},
+		{map[string]interface{}{"db_host": "[2001:db8::1]"}, "[2001:db8::1]:3306"},
+		{map[string]interface{}{"db_endpoint": "db.example.com:3306", "db_port": 13306}, "db.example.com:13306"},
+		{map[string]interface{}{"db_endpoint": "db.example.com:3307"}, "db.example.com:3307"},
+	}
+
+	for _, c := range cases {
+		pfConf := map[string]string{}
+		if v, ok := c.confMap["db_endpoint"].(string); ok {
+			pfConf["db_endpoint"] = v
+		}
+		setDBHostPort(pfConf, c.confMap)
+		if pfConf["db_endpoint"] != c.want {
+			t.Errorf("%v: got %s, want %s", c.confMap, pfConf["db_endpoint"], c.want)
+		}
+	}
+}
+
+func TestSplitDBEndpoint(t *testing.T) {
+	cases := []struct {
+		endpoint string
+		host     string
+		port     string
+	}{
+		{"db.example.com", "db.example.com", "3306"},
+		{"db.example.com:3306", "db.example.com", "3306"},
+		{"db.example.com:33060", "db.example.com", "33060"},
+		{"[2001:db8::1]:33060", "2001:db8::1", "33060"},
+		{"[2001:db8::1]", "2001:db8::1", "3306"},
+		{"2001:db8::1", "2001:db8::1", "3306"},
+	}
+
+	for _, c := range cases {
+		host, port := splitDBEndpoint(c.endpoint)
+		if host != c.host || port != c.port {
+			t.Errorf("%s: got %s %s, want %s %s", c.endpoint, host, port, c.host, c.port)
+		}
+	}
+}
+
+func TestSessionManagerPluginVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the plugin")
+	}
+
+	plugin := func(output string) string {
+		path := filepath.Join(t.TempDir(), "session-manager-plugin")
+		if err := os.WriteFile(path, []byte("#!/bin/sh\necho "+output+"\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if v, err := sessionManagerPluginVersion(plugin("1.2.650.0")); err != nil || v.String() != "1.2.650.0" {
+		t.Fatalf("unexpected version %v: %v", v, err)
+	}
+	if _, err := sessionManagerPluginVersion(plugin("1.1.61.0")); err == nil || !strings.Contains(err.Error(), "1.2.0.0 or later") {
+		t.Fatalf("expected an old plugin to be rejected, got %v", err)
+	}
+	if v, err := sessionManagerPluginVersion(plugin("unknown")); err != nil || v != nil {
+		t.Fatalf("expected an unknown version to be tolerated, got %v: %v", v, err)
+	}
+	if _, err := sessionManagerPluginVersion(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected an error for a missing plugin")
+	}
+}
+
+func TestSessionManagerPluginArgs(t *testing.T) {
+	args, env := sessionManagerPluginArgs(version.Must(version.NewVersion("1.2.650.0")), "{response}", "ap-northeast-1", "dev", "{request}", "https://ssm")
+	if args[0] != "AWS_SSM_START_SESSION_RESPONSE" || len(env) != 1 || env[0] != "AWS_SSM_START_SESSION_RESPONSE={response}" {
+		t.Fatalf("expected the response in the environment, got %v %v", args, env)
+	}
+
+	for _, v := range []*version.Version{version.Must(version.NewVersion("1.2.463.0")), nil} {
+		args, env := sessionManagerPluginArgs(v, "{response}", "ap-northeast-1", "dev", "{request}", "https://ssm")
+		want := []string{"{response}", "ap-northeast-1", "StartSession", "dev", "{request}", "https://ssm"}
+		if strings.Join(args, " ") != strings.Join(want, " ") || env != nil {
+			t.Fatalf("%v: expected the response as an argument, got %v %v", v, args, env)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package port_forward
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// trackedSession is an SSM session opened by a provider process. Sessions are
+// recorded on disk so that a later run can terminate the ones left behind by
+// a process that was killed before Cleanup ran.
+type trackedSession struct {
+	SessionID string `json:"session_id"`
+	Target    string `json:"target"`
+	PID       int    `json:"pid"`
+}
+
+var sessionTrackerMu sync.Mutex
+
+// sessionTrackerPath returns the file sessions are recorded in. It is a
+// variable so that tests can point it at a temporary directory.
+var sessionTrackerPath = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "terraform-provider-mysql", "ssm-sessions.json"), nil
+}
+
+func loadTrackedSessions(path string) ([]trackedSession, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []trackedSession
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func saveTrackedSessions(path string, sessions []trackedSession) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// updateTrackedSessions applies f to the recorded sessions. Tracking is best
+// effort, so failures are only logged.
+func updateTrackedSessions(f func([]trackedSession) []trackedSession) {
+	sessionTrackerMu.Lock()
+	defer sessionTrackerMu.Unlock()
+
+	path, err := sessionTrackerPath()
+	if err == nil {
+		var sessions []trackedSession
+		sessions, err = loadTrackedSessions(path)
+		if err == nil {
+			err = saveTrackedSessions(path, f(sessions))
+		}
+	}
+	if err != nil {
+		logf(logWarn, "failed to update tracked SSM sessions: %s", err)
+	}
+}
+
+func trackSession(target, sessionID string) {
+	updateTrackedSessions(func(sessions []trackedSession) []trackedSession {
+		return append(sessions, trackedSession{SessionID: sessionID, Target: target, PID: os.Getpid()})
+	})
+}
+
+func untrackSession(sessionID string) {
+	updateTrackedSessions(func(sessions []trackedSession) []trackedSession {
+		kept := sessions[:0]
+		for _, s := range sessions {
+			if s.SessionID != sessionID {
+				kept = append(kept, s)
+			}
+		}
+		return kept
+	})
+}
+
+// terminateStaleSessions terminates the recorded sessions to target whose
+// provider process is gone. Sessions of running processes are left alone, as
+// they may belong to a concurrent run.
+func terminateStaleSessions(target string, terminate func(sessionID string) error) {
+	updateTrackedSessions(func(sessions []trackedSession) []trackedSession {
+		kept := sessions[:0]
+		for _, s := range sessions {
+			if s.Target != target || processAlive(s.PID) {
+				kept = append(kept, s)
+				continue
+			}
+
+			logf(logDebug, "terminating SSM session %s to %s left behind by process %d", s.SessionID, s.Target, s.PID)
+			if err := terminate(s.SessionID); err != nil {
+				// The session has most likely timed out already.
+				logf(logWarn, "failed to terminate stale SSM session %s: %s", s.SessionID, err)
+			}
+		}
+		return kept
+	})
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// On Windows FindProcess already fails for processes that don't exist,
+	// and signals other than Kill are not supported.
+	if runtime.GOOS == "windows" {
+		return true
+	}
+
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// isSessionLimitError reports whether StartSession was rejected because too
+// many sessions are open. SSM has no dedicated error code for this, so the
+// message is matched.
+func isSessionLimitError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "session") &&
+		(strings.Contains(msg, "limit") || strings.Contains(msg, "maximum number") || strings.Contains(msg, "concurrent"))
+}
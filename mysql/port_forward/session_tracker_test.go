@@ -0,0 +1,74 @@
+package port_forward
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTerminateStaleSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssm-sessions.json")
+	orig := sessionTrackerPath
+	sessionTrackerPath = func() (string, error) { return path, nil }
+	defer func() { sessionTrackerPath = orig }()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	deadPID := cmd.Process.Pid
+
+	err := saveTrackedSessions(path, []trackedSession{
+		{SessionID: "stale", Target: "i-1", PID: deadPID},
+		{SessionID: "running", Target: "i-1", PID: os.Getpid()},
+		{SessionID: "other-target", Target: "i-2", PID: deadPID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var terminated []string
+	terminateStaleSessions("i-1", func(sessionID string) error {
+		terminated = append(terminated, sessionID)
+		return errors.New("already terminated")
+	})
+
+	if len(terminated) != 1 || terminated[0] != "stale" {
+		t.Fatalf("unexpected sessions terminated: %v", terminated)
+	}
+
+	sessions, err := loadTrackedSessions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].SessionID != "running" || sessions[1].SessionID != "other-target" {
+		t.Fatalf("unexpected sessions kept: %v", sessions)
+	}
+
+	untrackSession("running")
+	trackSession("i-3", "new")
+	sessions, err = loadTrackedSessions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[1].SessionID != "new" || sessions[1].PID != os.Getpid() {
+		t.Fatalf("unexpected sessions after untrack/track: %v", sessions)
+	}
+}
+
+func TestIsSessionLimitError(t *testing.T) {
+	cases := map[string]bool{
+		"ThrottlingException: Maximum number of sessions reached":      true,
+		"ServiceQuotaExceeded: concurrent session limit exceeded":      true,
+		"TargetNotConnected: i-0123456789abcdef0 is not connected":     false,
+		"InvalidDocument: document AWS-StartSSHSession does not exist": false,
+	}
+
+	for msg, want := range cases {
+		if got := isSessionLimitError(errors.New(msg)); got != want {
+			t.Errorf("%q: got %v, want %v", msg, got, want)
+		}
+	}
+}
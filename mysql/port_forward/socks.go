@@ -0,0 +1,175 @@
+package port_forward
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	socksVersion        = 5
+	socksNoAuth         = 0
+	socksNoAcceptable   = 0xff
+	socksCmdConnect     = 1
+	socksAtypIPv4       = 1
+	socksAtypDomain     = 3
+	socksAtypIPv6       = 4
+	socksSucceeded      = 0
+	socksGeneralError   = 1
+	socksCmdNotSupport  = 7
+	socksAtypNotSupport = 8
+)
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// serveSOCKS starts a SOCKS5 server on socksPort whose connections are made
+// through dial, i.e. through the SSH client of the tunnel. Only CONNECT
+// without authentication is supported, so a wildcard local_bind_address is
+// replaced by the loopback address of its family, like for LocalAddr; it
+// would otherwise open a proxy into the network behind the bastion to anyone
+// who can reach the host.
+func (pfConf *portFowardConfig) serveSOCKS(ctx context.Context, dial dialContextFunc) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(pfConf.localHost(), strconv.Itoa(int(pfConf.socksPort))))
+	if err != nil {
+		return withPhase(ErrPortBind, fmt.Errorf("socks_local_port: %w", err))
+	}
+	pfConf.socksPort = uint16(listener.Addr().(*net.TCPAddr).Port)
+	logf(logDebug, "SOCKS5 proxy listening on %s", listener.Addr())
+
+	registerCleanup(func() error {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		return nil
+	})
+	closeOnDone(ctx, listener)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					logf(logWarn, "SOCKS5 proxy on %s stopped: %s", listener.Addr(), err)
+				}
+				return
+			}
+			go pfConf.handleSOCKS(ctx, conn, dial)
+		}
+	}()
+
+	return nil
+}
+
+func (pfConf *portFowardConfig) handleSOCKS(ctx context.Context, conn net.Conn, dial dialContextFunc) {
+	addr, err := socksHandshake(conn)
+	if err != nil {
+		logf(logWarn, "SOCKS5 request from %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	dialCtx := ctx
+	if pfConf.innerDialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, pfConf.innerDialTimeout)
+		defer cancel()
+	}
+
+	remoteConn, err := dial(dialCtx, "tcp", addr)
+	if err != nil {
+		logf(logWarn, "SOCKS5 connect to %s through the tunnel: %s", addr, err)
+		socksReply(conn, socksGeneralError)
+		conn.Close()
+		return
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		remoteConn.Close()
+		conn.Close()
+		return
+	}
+
+	pipe(ctx, conn, remoteConn)
+}
+
+// socksHandshake negotiates the authentication method and reads a CONNECT
+// request, returning the address to connect to.
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	noAuth := false
+	for _, m := range methods {
+		if m == socksNoAuth {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", fmt.Errorf("client does not offer the no authentication method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socksCmdConnect {
+		socksReply(conn, socksCmdNotSupport)
+		return "", fmt.Errorf("unsupported command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksAtypNotSupport)
+		return "", fmt.Errorf("unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply sends a reply without a bound address, which clients don't need
+// for CONNECT.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
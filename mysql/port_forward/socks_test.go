@@ -0,0 +1,98 @@
+package port_forward
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestServeSOCKS(t *testing.T) {
+	payload := []byte("hello through the tunnel")
+	port := servePayload(t, payload)
+
+	var dialed string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	conf := &portFowardConfig{localBindAddress: "127.0.0.1"}
+	if err := conf.serveSOCKS(context.Background(), dial); err != nil {
+		t.Fatal(err)
+	}
+	defer Cleanup()
+	if conf.socksPort == 0 {
+		t.Fatal("expected the port picked by the OS to be recorded")
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(conf.socksPort))), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port)))
+	conn, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("got %q, want %q", got, payload)
+	}
+	if dialed != target {
+		t.Fatalf("expected %s to be dialed through the tunnel, got %s", target, dialed)
+	}
+}
+
+func TestServeSOCKS_wildcardBindAddress(t *testing.T) {
+	conf := &portFowardConfig{localBindAddress: "0.0.0.0"}
+	if err := conf.serveSOCKS(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Cleanup()
+
+	port := strconv.Itoa(int(conf.socksPort))
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	// Reaches a wildcard listener on Linux, where all of 127.0.0.0/8 is
+	// local, but not one on 127.0.0.1.
+	if conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.2", port)); err == nil {
+		conn.Close()
+		t.Fatal("expected the proxy to only listen on the loopback address")
+	}
+}
+
+func TestParsePFConfig_socksRemotePortForward(t *testing.T) {
+	confMap := map[string]string{
+		"remote_endpoint":         "i-0123456789abcdef0:22",
+		"db_endpoint":             "db.example.com:3306",
+		"use_remote_port_forward": "true",
+		"socks_local_port":        "1080",
+	}
+	if _, err := ParsePFConfig(confMap, 3306); err == nil || !strings.Contains(err.Error(), "socks_local_port") {
+		t.Fatalf("expected socks_local_port to be rejected for remote port forwarding, got %v", err)
+	}
+
+	confMap["use_remote_port_forward"] = "false"
+	confMap["ssh_private_key"] = "key"
+	conf, err := ParsePFConfig(confMap, 3306)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.socksPort != 1080 {
+		t.Fatalf("got socks port %d, want 1080", conf.socksPort)
+	}
+}
@@ -0,0 +1,165 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// Static privileges understood by every supported server.
+var commonPrivileges = []string{
+	"ALL", "ALL PRIVILEGES", "ALTER", "ALTER ROUTINE", "CREATE",
+	"CREATE ROUTINE", "CREATE TABLESPACE", "CREATE TEMPORARY TABLES",
+	"CREATE USER", "CREATE VIEW", "DELETE", "DROP", "EVENT", "EXECUTE",
+	"FILE", "GRANT OPTION", "INDEX", "INSERT", "LOCK TABLES", "PROCESS",
+	"PROXY", "REFERENCES", "RELOAD", "REPLICATION CLIENT",
+	"REPLICATION SLAVE", "SELECT", "SHOW DATABASES", "SHOW VIEW",
+	"SHUTDOWN", "SUPER", "TRIGGER", "UPDATE", "USAGE",
+}
+
+// Aurora MySQL extensions.
+var auroraPrivileges = []string{
+	"LOAD FROM S3", "SELECT INTO S3", "INVOKE LAMBDA", "INVOKE SAGEMAKER",
+	"INVOKE COMPREHEND",
+}
+
+// Role privileges and dynamic privileges introduced in MySQL 8. Plugins and
+// later releases register dynamic privileges of their own, so this list is
+// not exhaustive, see serverPrivileges.
+var mysql8Privileges = []string{
+	"CREATE ROLE", "DROP ROLE",
+	"ALLOW_NONEXISTENT_DEFINER", "APPLICATION_PASSWORD_ADMIN", "AUDIT_ABORT_EXEMPT", "AUDIT_ADMIN",
+	"AUTHENTICATION_POLICY_ADMIN", "BACKUP_ADMIN", "BINLOG_ADMIN",
+	"BINLOG_ENCRYPTION_ADMIN", "CLONE_ADMIN", "CONNECTION_ADMIN",
+	"ENCRYPTION_KEY_ADMIN", "FIREWALL_ADMIN", "FIREWALL_EXEMPT",
+	"FIREWALL_USER", "FLUSH_OPTIMIZER_COSTS", "FLUSH_PRIVILEGES",
+	"FLUSH_STATUS", "FLUSH_TABLES", "FLUSH_USER_RESOURCES",
+	"GROUP_REPLICATION_ADMIN", "GROUP_REPLICATION_STREAM",
+	"INNODB_REDO_LOG_ARCHIVE", "INNODB_REDO_LOG_ENABLE", "NDB_STORED_USER",
+	"OPTIMIZE_LOCAL_TABLE", "PASSWORDLESS_USER_ADMIN", "PERSIST_RO_VARIABLES_ADMIN",
+	"REPLICATION_APPLIER", "REPLICATION_SLAVE_ADMIN",
+	"RESOURCE_GROUP_ADMIN", "RESOURCE_GROUP_USER", "ROLE_ADMIN",
+	"SENSITIVE_VARIABLES_OBSERVER", "SERVICE_CONNECTION_ADMIN",
+	"SESSION_VARIABLES_ADMIN", "SET_ANY_DEFINER", "SET_USER_ID",
+	"SHOW_ROUTINE", "SKIP_QUERY_REWRITE", "SYSTEM_USER",
+	"SYSTEM_VARIABLES_ADMIN", "TABLE_ENCRYPTION_ADMIN", "TELEMETRY_LOG_ADMIN",
+	"TP_CONNECTION_ADMIN", "TRANSACTION_GTID_TAG", "VERSION_TOKEN_ADMIN",
+	"XA_RECOVER_ADMIN",
+}
+
+// Privileges specific to MariaDB 10.5+.
+var mariaDBPrivileges = []string{
+	"BINLOG ADMIN", "BINLOG MONITOR", "BINLOG REPLAY", "CONNECTION ADMIN",
+	"DELETE HISTORY", "FEDERATED ADMIN", "READ_ONLY ADMIN",
+	"REPLICA MONITOR", "REPLICATION MASTER ADMIN", "REPLICATION REPLICA",
+	"REPLICATION REPLICA ADMIN", "REPLICATION SLAVE ADMIN", "SET USER",
+	"SHOW CREATE ROUTINE", "SLAVE MONITOR",
+}
+
+var privilegeColumnsRe = regexp.MustCompile(`\s*\(.*\)\s*$`)
+
+// dynamicPrivilegeRe matches the form of MySQL 8 dynamic privileges, e.g.
+// BINLOG_ADMIN, as opposed to static ones such as SELECT or CREATE USER.
+var dynamicPrivilegeRe = regexp.MustCompile(`^[A-Z0-9]+(_[A-Z0-9]+)+$`)
+
+// normalizePrivilege upper-cases a privilege and strips any column list,
+// e.g. "select (id, name)" becomes "SELECT".
+func normalizePrivilege(privilege string) string {
+	privilege = privilegeColumnsRe.ReplaceAllString(privilege, "")
+	return strings.Join(strings.Fields(strings.ToUpper(privilege)), " ")
+}
+
+func privilegeSet(lists ...[]string) map[string]bool {
+	set := map[string]bool{}
+	for _, list := range lists {
+		for _, p := range list {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+var allKnownPrivileges = privilegeSet(commonPrivileges, auroraPrivileges, mysql8Privileges, mariaDBPrivileges)
+
+// validatePrivilege is a schema ValidateFunc warning about privileges that
+// no supported server is known to have, with suggestions for typos. The
+// server may still know them, e.g. dynamic privileges of newer releases or
+// plugins, so they are left for serverPrivileges to check before granting.
+func validatePrivilege(v interface{}, k string) (ws []string, errors []error) {
+	if err := checkPrivilege(v.(string), allKnownPrivileges); err != nil {
+		ws = append(ws, fmt.Sprintf("%s: %s; it is passed to the server as is", k, err))
+	}
+	return
+}
+
+// serverPrivileges describes the privileges the connected server accepts.
+type serverPrivileges struct {
+	known map[string]bool
+	// dynamic is set for MySQL 8, which also accepts dynamic privileges
+	// registered by plugins or releases newer than known.
+	dynamic bool
+}
+
+// supportedPrivileges returns the privileges the connected server accepts.
+func supportedPrivileges(ctx context.Context, db *sql.DB) (serverPrivileges, error) {
+	versionString, err := serverVersionString(ctx, db)
+	if err != nil {
+		return serverPrivileges{}, err
+	}
+
+	if strings.Contains(versionString, "MariaDB") {
+		return serverPrivileges{known: privilegeSet(commonPrivileges, mariaDBPrivileges)}, nil
+	}
+
+	currentVersion, err := serverVersion(ctx, db)
+	if err != nil {
+		return serverPrivileges{}, err
+	}
+
+	requiredVersion, _ := version.NewVersion("8.0.0")
+	if currentVersion.LessThan(requiredVersion) {
+		return serverPrivileges{known: privilegeSet(commonPrivileges, auroraPrivileges)}, nil
+	}
+
+	return serverPrivileges{known: privilegeSet(commonPrivileges, auroraPrivileges, mysql8Privileges), dynamic: true}, nil
+}
+
+// check rejects a privilege the server lacks: a known privilege of another
+// flavor or version, or an unknown static one. Unknown dynamic privileges
+// are left for MySQL 8 to check.
+func (s serverPrivileges) check(privilege string) error {
+	name := normalizePrivilege(privilege)
+	if s.dynamic && !allKnownPrivileges[name] && dynamicPrivilegeRe.MatchString(name) {
+		return nil
+	}
+	return checkPrivilege(privilege, s.known)
+}
+
+func checkPrivilege(privilege string, known map[string]bool) error {
+	name := normalizePrivilege(privilege)
+	if known[name] {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%q is not a valid privilege", privilege)
+	if suggestions := similarPrivileges(name, known); len(suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", strings.Join(suggestions, " or "))
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+func similarPrivileges(name string, known map[string]bool) []string {
+	var suggestions []string
+	for p := range known {
+		if levenshtein(name, p) <= 2 || strings.ReplaceAll(p, "_", " ") == strings.ReplaceAll(name, "_", " ") {
+			suggestions = append(suggestions, fmt.Sprintf("%q", p))
+		}
+	}
+	sort.Strings(suggestions)
+	return suggestions
+}
@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePrivilege(t *testing.T) {
+	for _, v := range []string{
+		"SELECT",
+		"select",
+		"ALL PRIVILEGES",
+		"Show  Databases",
+		"SELECT (id, name)",
+		"SYSTEM_VARIABLES_ADMIN",
+		"SET_ANY_DEFINER",
+		"BINLOG MONITOR",
+	} {
+		if ws, errs := validatePrivilege(v, "privileges"); len(ws) > 0 || len(errs) > 0 {
+			t.Errorf("%s: unexpected warnings %v or errors %v", v, ws, errs)
+		}
+	}
+
+	ws, errs := validatePrivilege("SELCT", "privileges")
+	if len(errs) > 0 {
+		t.Errorf("unknown privileges should not be errors at plan time, got %v", errs)
+	}
+	if len(ws) != 1 {
+		t.Fatalf("expected a warning for SELCT, got %v", ws)
+	}
+	if !strings.Contains(ws[0], `did you mean "SELECT"`) {
+		t.Errorf("expected a suggestion, got %s", ws[0])
+	}
+
+	if ws, _ := validatePrivilege("AWS_LOAD_S3_ACCESS", "privileges"); len(ws) != 1 {
+		t.Errorf("expected a warning for AWS_LOAD_S3_ACCESS, got %v", ws)
+	}
+}
+
+func TestServerPrivileges_check(t *testing.T) {
+	mysql57 := serverPrivileges{known: privilegeSet(commonPrivileges, auroraPrivileges)}
+	if err := mysql57.check("ROLE_ADMIN"); err == nil {
+		t.Error("ROLE_ADMIN should be rejected on MySQL 5.7")
+	}
+
+	mariadb := serverPrivileges{known: privilegeSet(commonPrivileges, mariaDBPrivileges)}
+	err := mariadb.check("BINLOG_ADMIN")
+	if err == nil {
+		t.Fatal("BINLOG_ADMIN should be rejected on MariaDB")
+	}
+	if !strings.Contains(err.Error(), `"BINLOG ADMIN"`) {
+		t.Errorf("expected MariaDB spelling to be suggested, got %s", err)
+	}
+
+	mysql8 := serverPrivileges{known: privilegeSet(commonPrivileges, auroraPrivileges, mysql8Privileges), dynamic: true}
+	for _, v := range []string{"AWS_LOAD_S3_ACCESS", "AUDIT_ABORT_EXEMPT", "FIREWALL_EXEMPT", "select"} {
+		if err := mysql8.check(v); err != nil {
+			t.Errorf("%s should be passed to MySQL 8: %s", v, err)
+		}
+	}
+	for _, v := range []string{"DROP DATABASE", "SELCT", "BINLOG MONITOR"} {
+		if err := mysql8.check(v); err == nil {
+			t.Errorf("%s should be rejected on MySQL 8", v)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"SELECT", "SELECT", 0},
+		{"SELCT", "SELECT", 1},
+		{"GRANT", "GRANT OPTION", 7},
+		{"", "DROP", 4},
+	}
+
+	for _, c := range cases {
+		if got := levenshtein(c.a, c.b); got != c.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
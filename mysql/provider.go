@@ -1,23 +1,36 @@
 package mysql
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/url"
+	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/hashicorp/go-version"
 	"github.com/moto-taka/terraform-provider-mysql/mysql/port_forward"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 
 	"golang.org/x/net/proxy"
 )
@@ -27,13 +40,55 @@ const (
 	nativePasswords    = "native"
 )
 
+// tunnelMaxOpenConns caps the connection pool when max_open_conns is not set
+// and traffic goes through a tunnel. Every connection is a separate channel on
+// the bastion's SSH connection (or SSM session), and sshd refuses channels
+// beyond MaxSessions, which Terraform's default parallelism easily exceeds.
+const tunnelMaxOpenConns = 2
+
+// tunnelMaxConnLifetime recycles pooled connections when max_conn_lifetime_sec
+// is not set and traffic goes through a tunnel. A connection that outlives the
+// SSM session or SSH connection it was opened on is dead, and would otherwise
+// only be noticed by the next query using it.
+const tunnelMaxConnLifetime = 60 * time.Second
+
 type MySQLConfiguration struct {
 	Config          *mysql.Config
 	MaxConnLifetime time.Duration
 	MaxOpenConns    int
+	ReadOnly        bool
+	InitStatements  []string
+	// ExecAttempts is how often a write is tried when it fails with a
+	// deadlock or lock wait timeout.
+	ExecAttempts int
+	// HealthCheckQuery has to succeed, after Ping, before a connection is
+	// considered ready. Empty skips it.
+	HealthCheckQuery string
+	// Tunneled is set when connections go through an SSM or SSH tunnel.
+	Tunneled bool
+
+	// sharePools is set when warm_connection is set or a tunnel is
+	// configured, so that all resources share pools, and MaxOpenConns
+	// limits the connections of the whole provider. pools holds one per
+	// operation, opened by the first of them, as connections can only be
+	// tagged when they are made.
+	sharePools bool
+	pools      map[string]*sql.DB
+	poolsMu    sync.Mutex
+}
+
+// closePools closes the pools shared by resources; they are reopened by
+// the next connectToMySQL.
+func (conf *MySQLConfiguration) closePools() {
+	conf.poolsMu.Lock()
+	defer conf.poolsMu.Unlock()
+	for operation, db := range conf.pools {
+		db.Close()
+		delete(conf.pools, operation)
+	}
 }
 
-func Provider() terraform.ResourceProvider {
+func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"endpoint": {
@@ -50,6 +105,14 @@ func Provider() terraform.ResourceProvider {
 				},
 			},
 
+			// Overrides the guess from the endpoint, which takes absolute
+			// paths for unix sockets.
+			"net": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"tcp", "unix"}, false),
+			},
+
 			"username": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -69,7 +132,7 @@ func Provider() terraform.ResourceProvider {
 					"ALL_PROXY",
 					"all_proxy",
 				}, nil),
-				ValidateFunc: validation.StringMatch(regexp.MustCompile("^socks5h?://.*:\\d+$"), "The proxy URL is not a valid socks url."),
+				ValidateFunc: validateProxyURL,
 			},
 
 			"tls": {
@@ -93,6 +156,82 @@ func Provider() terraform.ResourceProvider {
 				Optional: true,
 			},
 
+			"exec_retry_attempts": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      3,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+
+			"health_check_query": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "SELECT 1",
+			},
+
+			"azure": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"azure_single_server": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"init_statements": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringIsNotWhiteSpace,
+				},
+			},
+
+			"warm_connection": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"tunnel_info_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"keep_tunnel_open": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MYSQL_KEEP_TUNNEL_OPEN", false),
+			},
+
+			"tunnel_log_level": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("MYSQL_TUNNEL_LOG_LEVEL", port_forward.DefaultLogLevel()),
+				ValidateFunc: validation.StringInSlice([]string{"off", "error", "warn", "info", "debug"}, false),
+			},
+
+			"read_only": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"validate_connection": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"reject_read_only": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
 			"authentication_plugin": {
 				Type:         schema.TypeString,
 				Optional:     true,
@@ -100,34 +239,219 @@ func Provider() terraform.ResourceProvider {
 				ValidateFunc: validation.StringInSlice([]string{cleartextPasswords, nativePasswords}, true),
 			},
 
+			"time_zone": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(regexp.MustCompile(`^([+-]\d{1,2}:\d{2}|SYSTEM|[A-Za-z]+(/[A-Za-z0-9_+-]+)*)$`), "must be an offset like +00:00, SYSTEM or a named time zone like Asia/Tokyo"),
+			},
+
+			"server_public_key": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"tls_server_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"connection_attributes": {
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateDiagFunc: validateConnectionAttributes,
+			},
+
 			"aws_ssm_session_manager_client_config": {
-				Type:        schema.TypeList,
-				Optional:    true,
-				MaxItems:    1,
-				Description: "Configuration for use aws sesion manager.",
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"port_forward_client_config"},
+				Description:   "Configuration for use aws sesion manager.",
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"ec2_instance_id": {
-							Type:     schema.TypeString,
-							Required: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"aws_ssm_session_manager_client_config.0.ec2_instance_id", "aws_ssm_session_manager_client_config.0.ec2_instance_tag"},
+						},
+						"ec2_instance_tag": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"aws_ssm_session_manager_client_config.0.ec2_instance_id", "aws_ssm_session_manager_client_config.0.ec2_instance_tag"},
+							ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[^=]+=`), "must be in key=value format"),
 						},
 						"rds_endpoint": {
-							Type:     schema.TypeString,
-							Required: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"aws_ssm_session_manager_client_config.0.rds_endpoint", "aws_ssm_session_manager_client_config.0.rds_identifier", "aws_ssm_session_manager_client_config.0.db_host"},
+						},
+						"rds_identifier": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"aws_ssm_session_manager_client_config.0.rds_endpoint", "aws_ssm_session_manager_client_config.0.rds_identifier", "aws_ssm_session_manager_client_config.0.db_host"},
+						},
+						"db_host": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"aws_ssm_session_manager_client_config.0.rds_endpoint", "aws_ssm_session_manager_client_config.0.rds_identifier", "aws_ssm_session_manager_client_config.0.db_host"},
+						},
+						"db_port": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IsPortNumber,
 						},
 						"use_remote_port_forward": {
 							Type:     schema.TypeBool,
 							Optional: true,
 							Default:  true,
 						},
+						"local_port": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IsPortNumber,
+						},
+						"local_bind_address": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "127.0.0.1",
+							ValidateFunc: validation.IsIPAddress,
+						},
+						"socks_local_port": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IsPortNumber,
+						},
+						"ssm_document_name": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"aws_ssm_endpoint_url": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("AWS_ENDPOINT_URL_SSM", ""),
+						},
+						"use_fips_endpoint": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("AWS_USE_FIPS_ENDPOINT", false),
+						},
+						"auto_reconnect": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"send_proxy_protocol": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"v1", "v2"}, false),
+						},
+						"verify_handshake": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
 						"ssh_user": {
 							Type:     schema.TypeString,
 							Optional: true,
 						},
 						"ssh_key_path": {
+							Type:          schema.TypeString,
+							Optional:      true,
+							ConflictsWith: []string{"aws_ssm_session_manager_client_config.0.ssh_private_key"},
+						},
+						"ssh_key_paths": {
+							Type:          schema.TypeList,
+							Optional:      true,
+							Elem:          &schema.Schema{Type: schema.TypeString},
+							ConflictsWith: []string{"aws_ssm_session_manager_client_config.0.ssh_private_key"},
+						},
+						"ssh_private_key": {
+							Type:          schema.TypeString,
+							Optional:      true,
+							Sensitive:     true,
+							ConflictsWith: []string{"aws_ssm_session_manager_client_config.0.ssh_key_path", "aws_ssm_session_manager_client_config.0.ssh_key_paths"},
+						},
+						"ssh_agent": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"ssh_password": {
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+						"inner_dial_timeout_sec": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      30,
+							ValidateFunc: validation.IntAtLeast(1),
+						},
+						"disable_known_hosts": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("MYSQL_SSH_DISABLE_KNOWN_HOSTS", false),
+						},
+						"known_hosts_scope": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							DefaultFunc:  schema.EnvDefaultFunc("MYSQL_SSH_KNOWN_HOSTS_SCOPE", "user"),
+							ValidateFunc: validation.StringInSlice([]string{"user", "tunnel", "memory"}, false),
+						},
+						"ssh_host_public_key": {
 							Type:     schema.TypeString,
 							Optional: true,
 						},
+						"ssh_next_hop": {
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"host": {
+										Type:     schema.TypeString,
+										Required: true,
+									},
+									"ssh_user": {
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"ssh_key_path": {
+										Type:          schema.TypeString,
+										Optional:      true,
+										ConflictsWith: []string{"aws_ssm_session_manager_client_config.0.ssh_next_hop.0.ssh_private_key"},
+									},
+									"ssh_private_key": {
+										Type:          schema.TypeString,
+										Optional:      true,
+										Sensitive:     true,
+										ConflictsWith: []string{"aws_ssm_session_manager_client_config.0.ssh_next_hop.0.ssh_key_path"},
+									},
+									"ssh_host_public_key": {
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+								},
+							},
+						},
+						"additional_forward": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"db_endpoint": {
+										Type:     schema.TypeString,
+										Required: true,
+									},
+									"local_port": {
+										Type:         schema.TypeInt,
+										Optional:     true,
+										ValidateFunc: validation.IsPortNumber,
+									},
+								},
+							},
+						},
 						"aws_profile": {
 							Type: schema.TypeString,
 							DefaultFunc: schema.MultiEnvDefaultFunc([]string{
@@ -144,14 +468,49 @@ func Provider() terraform.ResourceProvider {
 							}, ""),
 							Optional: true,
 						},
+						"aws_partition": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"aws", "aws-cn", "aws-us-gov", "aws-iso", "aws-iso-b"}, false),
+						},
+						"aws_shared_credentials_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("AWS_SHARED_CREDENTIALS_FILE", ""),
+						},
+						"aws_config_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("AWS_CONFIG_FILE", ""),
+						},
+						"aws_ca_bundle": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"assume_role_arn": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"assume_role_session_name": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							RequiredWith: []string{"aws_ssm_session_manager_client_config.0.assume_role_arn"},
+						},
+						"assume_role_duration_sec": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							RequiredWith: []string{"aws_ssm_session_manager_client_config.0.assume_role_arn"},
+							ValidateFunc: validation.IntBetween(900, 43200),
+						},
 					},
 				},
 			},
 			"port_forward_client_config": {
-				Type:        schema.TypeList,
-				Optional:    true,
-				MaxItems:    1,
-				Description: "Configuration for Port Forward.",
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				ConflictsWith: []string{"aws_ssm_session_manager_client_config"},
+				Description:   "Configuration for Port Forward.",
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"remote_host": {
@@ -159,14 +518,112 @@ func Provider() terraform.ResourceProvider {
 							Required: true,
 						},
 						"db_endpoint": {
-							Type:     schema.TypeString,
-							Required: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"port_forward_client_config.0.db_endpoint", "port_forward_client_config.0.db_host"},
+						},
+						"db_host": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ExactlyOneOf: []string{"port_forward_client_config.0.db_endpoint", "port_forward_client_config.0.db_host"},
+						},
+						"db_port": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IsPortNumber,
+						},
+						"local_port": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IsPortNumber,
+						},
+						"local_bind_address": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "127.0.0.1",
+							ValidateFunc: validation.IsIPAddress,
+						},
+						"socks_local_port": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IsPortNumber,
+						},
+						"auto_reconnect": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"send_proxy_protocol": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"v1", "v2"}, false),
+						},
+						"verify_handshake": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"connect_attempts": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      1,
+							ValidateFunc: validation.IntAtLeast(1),
+						},
+						"connect_retry_interval_sec": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      2,
+							ValidateFunc: validation.IntAtLeast(1),
 						},
 						"ssh_user": {
 							Type:     schema.TypeString,
 							Optional: true,
 						},
 						"ssh_key_path": {
+							Type:          schema.TypeString,
+							Optional:      true,
+							ConflictsWith: []string{"port_forward_client_config.0.ssh_private_key"},
+						},
+						"ssh_key_paths": {
+							Type:          schema.TypeList,
+							Optional:      true,
+							Elem:          &schema.Schema{Type: schema.TypeString},
+							ConflictsWith: []string{"port_forward_client_config.0.ssh_private_key"},
+						},
+						"ssh_private_key": {
+							Type:          schema.TypeString,
+							Optional:      true,
+							Sensitive:     true,
+							ConflictsWith: []string{"port_forward_client_config.0.ssh_key_path", "port_forward_client_config.0.ssh_key_paths"},
+						},
+						"ssh_agent": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"ssh_password": {
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+						"inner_dial_timeout_sec": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      30,
+							ValidateFunc: validation.IntAtLeast(1),
+						},
+						"disable_known_hosts": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							DefaultFunc: schema.EnvDefaultFunc("MYSQL_SSH_DISABLE_KNOWN_HOSTS", false),
+						},
+						"known_hosts_scope": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							DefaultFunc:  schema.EnvDefaultFunc("MYSQL_SSH_KNOWN_HOSTS_SCOPE", "user"),
+							ValidateFunc: validation.StringInSlice([]string{"user", "tunnel", "memory"}, false),
+						},
+						"ssh_host_public_key": {
 							Type:     schema.TypeString,
 							Optional: true,
 						},
@@ -175,75 +632,497 @@ func Provider() terraform.ResourceProvider {
 			},
 		},
 
-		ResourcesMap: map[string]*schema.Resource{
+		DataSourcesMap: tagOperations(map[string]*schema.Resource{
+			"mysql_grants":       dataSourceGrants(),
+			"mysql_tunnel_close": dataSourceTunnelClose(),
+		}),
+
+		ResourcesMap: tagOperations(map[string]*schema.Resource{
 			"mysql_database":      resourceDatabase(),
 			"mysql_grant":         resourceGrant(),
+			"mysql_plugin":        resourcePlugin(),
 			"mysql_role":          resourceRole(),
+			"mysql_sequence":      resourceSequence(),
+			"mysql_transaction":   resourceTransaction(),
 			"mysql_user":          resourceUser(),
 			"mysql_user_password": resourceUserPassword(),
-		},
+		}),
 
-		ConfigureFunc: providerConfigure,
+		ConfigureContextFunc: providerConfigure,
 	}
 }
 
-func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
 
 	var endpoint = d.Get("endpoint").(string)
 
-	proto := "tcp"
-	if len(endpoint) > 0 && endpoint[0] == '/' {
-		proto = "unix"
+	proto := endpointNetwork(endpoint, d.Get("net").(string))
+	if proto == "unix" && hasTunnelConfig(d) {
+		return nil, diag.Errorf("net: a tunnel forwards a TCP port, it can't be used with a unix socket endpoint")
+	}
+
+	username := d.Get("username").(string)
+	tlsConfig := d.Get("tls").(string)
+
+	// Azure Database for MySQL enforces TLS, and Single Server additionally
+	// expects logins in the form user@servername.
+	if d.Get("azure").(bool) || d.Get("azure_single_server").(bool) || isAzureEndpoint(endpoint) {
+		if !isConfigured(d, "tls", "MYSQL_TLS_CONFIG") {
+			tlsConfig = "true"
+		}
+		if d.Get("azure_single_server").(bool) {
+			username = azureSingleServerUsername(username, endpoint)
+		}
 	}
 
 	conf := mysql.Config{
-		User:                    d.Get("username").(string),
+		User:                    username,
 		Passwd:                  d.Get("password").(string),
 		Net:                     proto,
 		Addr:                    endpoint,
-		TLSConfig:               d.Get("tls").(string),
+		TLSConfig:               tlsConfig,
 		AllowNativePasswords:    d.Get("authentication_plugin").(string) == nativePasswords,
 		AllowCleartextPasswords: d.Get("authentication_plugin").(string) == cleartextPasswords,
+		// After an Aurora failover the old writer is read-only for a while;
+		// the driver then drops the connection so that a new one reaches the
+		// new writer.
+		RejectReadOnly: d.Get("reject_read_only").(bool),
+	}
+
+	// The driver runs SET time_zone=<value> on every new connection, so the
+	// value has to be quoted here. ValidateFunc keeps quotes out of it.
+	if v := d.Get("time_zone").(string); v != "" {
+		conf.Params = map[string]string{"time_zone": fmt.Sprintf("'%s'", v)}
+	}
+
+	conf.ConnectionAttributes = connectionAttributes(d.Get("connection_attributes").(map[string]interface{}))
+
+	if v := d.Get("server_public_key").(string); v != "" {
+		name, err := registerServerPubKey(v)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		conf.ServerPubKey = name
+	}
+
+	if v := d.Get("tls_server_name").(string); v != "" {
+		name, err := registerTLSServerName(conf.TLSConfig, v)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		conf.TLSConfig = name
 	}
 
 	dialer, err := makeDialer(d)
 	if err != nil {
-		return nil, err
+		return nil, diag.FromErr(err)
 	}
 
-	mysql.RegisterDial("tcp", func(network string) (net.Conn, error) {
-		return dialer.Dial("tcp", network)
+	// RegisterDial is global to the process, so every provider configuration
+	// registers its dialer under a network name of its own. Otherwise aliased
+	// providers would replace each other's proxy settings.
+	network := fmt.Sprintf("tcp-mysql-provider-%d", atomic.AddUint64(&dialNetworkSeq, 1))
+	mysql.RegisterDialContext(network, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.Dial("tcp", addr)
 	})
+	if conf.Net == "tcp" {
+		conf.Net = network
+		// The driver only adds the default port for the plain "tcp" network.
+		conf.Addr = ensurePort(conf.Addr)
+	}
+
+	// Without a tunnel the driver connects to the endpoint directly and none
+	// of the port_forward code is involved.
+	tunneled := hasTunnelConfig(d)
+	if tunneled {
+		addr, err := openTunnel(ctx, d, endpoint)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		// Point the driver at the local end of the tunnel; the endpoint's host
+		// is not necessarily resolvable (or reachable) from here.
+		conf.Net = network
+		conf.Addr = addr
+	}
+
+	maxOpenConns := d.Get("max_open_conns").(int)
+	if tunneled && !isConfigured(d, "max_open_conns", "") {
+		log.Printf("[WARN] max_open_conns is not set, limiting connections through the tunnel to %d per operation (read or write)", tunnelMaxOpenConns)
+		maxOpenConns = tunnelMaxOpenConns
+	}
+
+	maxConnLifetime := time.Duration(d.Get("max_conn_lifetime_sec").(int)) * time.Second
+	if tunneled && !isConfigured(d, "max_conn_lifetime_sec", "") {
+		maxConnLifetime = tunnelMaxConnLifetime
+	}
+
+	mysqlConf := &MySQLConfiguration{
+		Config:           &conf,
+		MaxConnLifetime:  maxConnLifetime,
+		MaxOpenConns:     maxOpenConns,
+		ExecAttempts:     d.Get("exec_retry_attempts").(int),
+		ReadOnly:         d.Get("read_only").(bool),
+		HealthCheckQuery: d.Get("health_check_query").(string),
+		Tunneled:         tunneled,
+		sharePools:       tunneled,
+	}
+
+	for _, v := range d.Get("init_statements").([]interface{}) {
+		mysqlConf.InitStatements = append(mysqlConf.InitStatements, v.(string))
+	}
+
+	if d.Get("validate_connection").(bool) {
+		if err := validateConnection(ctx, mysqlConf); err != nil {
+			return nil, diag.FromErr(err)
+		}
+	}
+
+	if d.Get("warm_connection").(bool) {
+		warmConnection(ctx, mysqlConf)
+	}
+
+	return mysqlConf, nil
+}
+
+// endpointNetwork returns the network to connect to endpoint with: network if
+// it is set, otherwise unix for an absolute path and tcp for anything else.
+func endpointNetwork(endpoint string, network string) string {
+	if network != "" {
+		return network
+	}
+	if len(endpoint) > 0 && endpoint[0] == '/' {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// hasTunnelConfig reports whether the connection goes through an SSM or SSH
+// tunnel.
+func hasTunnelConfig(d *schema.ResourceData) bool {
+	_, ssm := d.GetOk("aws_ssm_session_manager_client_config")
+	_, pf := d.GetOk("port_forward_client_config")
+	return ssm || pf
+}
+
+// openTunnel starts the configured tunnel and returns the local address to
+// connect to instead of the endpoint.
+func openTunnel(ctx context.Context, d *schema.ResourceData, endpoint string) (string, error) {
+	// The level applies to every tunnel of the process, as the log is shared
+	// by all provider configurations anyway.
+	if err := port_forward.SetLogLevel(d.Get("tunnel_log_level").(string)); err != nil {
+		return "", err
+	}
 
 	sessionConf, pfConfMap, err := port_forward.ParseSessionConfig(d)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	if pfConfMap == nil {
 		pfConfMap, err = port_forward.ParsePFConfigMap(d)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+	}
+	// The tunnel listens on the endpoint's port, 3306 if it has none.
+	var lp uint64
+	if _, port, err := net.SplitHostPort(ensurePort(endpoint)); err == nil {
+		lp, err = strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("endpoint: invalid port %q", port)
 		}
 	}
-	lp, _ := strconv.Atoi(strings.SplitN(d.Get("endpoint").(string), ":", 2)[1])
 	pfConf, err := port_forward.ParsePFConfig(pfConfMap, uint16(lp))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if err := port_forward.Connect(sessionConf, pfConf); err != nil {
-		return nil, err
+	if d.Get("keep_tunnel_open").(bool) {
+		pfConf.KeepOpen()
+	}
+
+	// ctx ends with the ConfigureProvider call, while the tunnel has to stay
+	// up for the rest of the run; it is torn down by port_forward.Cleanup.
+	if err := port_forward.Connect(context.WithoutCancel(ctx), sessionConf, pfConf); err != nil {
+		return "", tunnelSetupError(err)
+	}
+
+	if path := d.Get("tunnel_info_path").(string); path != "" {
+		if err := pfConf.WriteTunnelInfo(path); err != nil {
+			return "", err
+		}
+	}
+
+	return pfConf.LocalAddr(), nil
+}
+
+// tunnelSetupError adds a hint on what to check to a failure of opening the
+// tunnel, depending on the phase it failed in.
+func tunnelSetupError(err error) error {
+	var hint string
+	switch {
+	case errors.Is(err, port_forward.ErrSSMStartSession):
+		hint = "check that the credentials allow ssm:StartSession and that the SSM agent on the instance is online"
+	case errors.Is(err, port_forward.ErrSSHConnect):
+		hint = "check that the SSH host is reachable from here, e.g. its security group and remote_host"
+	case errors.Is(err, port_forward.ErrSSHHandshake):
+		hint = "check ssh_user, the SSH key and the host key settings"
+	case errors.Is(err, port_forward.ErrPortBind):
+		hint = "the local port is probably in use; set local_port to a free port"
+	case errors.Is(err, port_forward.ErrReadinessTimeout):
+		hint = "the tunnel is open but nothing answers on the forwarded port; check db_endpoint and that the database accepts connections from the tunnel host"
+	default:
+		return fmt.Errorf("opening the tunnel: %w", err)
+	}
+	return fmt.Errorf("opening the tunnel: %w\n%s", err, hint)
+}
+
+// validateConnection makes sure the server (and the tunnel in front of it,
+// if any) is usable while configuring the provider, so that problems are
+// reported by plan instead of halfway through an apply.
+func validateConnection(ctx context.Context, conf *MySQLConfiguration) error {
+	// Not connectToMySQL, so that the pool can be closed again rather than
+	// shared with resources.
+	db, err := openPool(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("validate_connection: %s", err)
+	}
+	defer db.Close()
+
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("validate_connection: connected to %s as %s but SELECT 1 failed: %s", conf.Config.Addr, conf.Config.User, err)
+	}
+
+	return nil
+}
+
+// warmConnection opens the connection pool once, establishes one connection
+// and keeps it idle, so that resources reuse it instead of paying for the
+// TCP, SSH and MySQL handshakes on every operation. A failure is not fatal:
+// the server may not exist yet, in which case resources connect on their own.
+func warmConnection(ctx context.Context, conf *MySQLConfiguration) {
+	conf.sharePools = true
+	db, err := connectToMySQL(context.WithValue(ctx, operationKey{}, operationRead), conf)
+	if err != nil {
+		log.Printf("[WARN] warm_connection: %s", err)
+		return
+	}
+
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		log.Printf("[WARN] warm_connection: SELECT 1 failed: %s", err)
+		conf.closePools()
+	}
+}
+
+// dialNetworkSeq numbers the networks registered with the driver by
+// providerConfigure.
+var dialNetworkSeq uint64
+
+// tlsConfigSeq numbers the TLS configs registered with the driver by
+// providerConfigure.
+var tlsConfigSeq uint64
+
+// registerTLSServerName registers a TLS config for the given tls setting
+// that verifies the server's certificate against serverName and sends it as
+// SNI, instead of the host of the address connected to. Behind a tunnel that
+// is the local end of it, which e.g. an RDS Proxy's certificate doesn't name.
+func registerTLSServerName(tlsSetting string, serverName string) (string, error) {
+	tlsConf := &tls.Config{ServerName: serverName}
+	switch tlsSetting {
+	case "true":
+	case "skip-verify":
+		tlsConf.InsecureSkipVerify = true
+	default:
+		return "", fmt.Errorf("tls_server_name requires tls to be true or skip-verify")
+	}
+
+	// Registered per provider configuration, like the dialer, so that
+	// aliased providers don't replace each other's server name.
+	name := fmt.Sprintf("terraform-provider-mysql-%d", atomic.AddUint64(&tlsConfigSeq, 1))
+	if err := mysql.RegisterTLSConfig(name, tlsConf); err != nil {
+		return "", err
 	}
+	return name, nil
+}
 
-	return &MySQLConfiguration{
-		Config:          &conf,
-		MaxConnLifetime: time.Duration(d.Get("max_conn_lifetime_sec").(int)) * time.Second,
-		MaxOpenConns:    d.Get("max_open_conns").(int),
-	}, nil
+func ensurePort(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, "3306")
+	}
+	return addr
 }
 
 var identQuoteReplacer = strings.NewReplacer("`", "``")
 
+const azureMySQLSuffix = ".mysql.database.azure.com"
+
+func endpointHost(endpoint string) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return host
+}
+
+func isAzureEndpoint(endpoint string) bool {
+	return strings.HasSuffix(strings.ToLower(endpointHost(endpoint)), azureMySQLSuffix)
+}
+
+func azureSingleServerUsername(username string, endpoint string) string {
+	if strings.Contains(username, "@") {
+		return username
+	}
+	server := strings.SplitN(endpointHost(endpoint), ".", 2)[0]
+	return fmt.Sprintf("%s@%s", username, server)
+}
+
+// isConfigured reports whether the user set a provider argument explicitly,
+// either in the configuration or through its environment variable, as
+// opposed to it holding its default.
+func isConfigured(d *schema.ResourceData, key string, envVar string) bool {
+	if os.Getenv(envVar) != "" {
+		return true
+	}
+	raw := d.GetRawConfig()
+	if raw.IsNull() || !raw.IsKnown() {
+		return false
+	}
+	return !raw.GetAttr(key).IsNull()
+}
+
+// serverPubKeySeq numbers the server public keys registered with the driver
+// by providerConfigure.
+var serverPubKeySeq uint64
+
+// defaultProgramName tags the provider's connections in
+// performance_schema.session_connect_attrs unless connection_attributes
+// sets program_name itself.
+const defaultProgramName = "terraform-provider-mysql"
+
+// connectionAttributes formats the attributes sent when connecting the way
+// the driver expects them: comma-separated key:value pairs.
+func connectionAttributes(attrs map[string]interface{}) string {
+	pairs := []string{}
+	if _, ok := attrs["program_name"]; !ok {
+		pairs = append(pairs, "program_name:"+defaultProgramName)
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pairs = append(pairs, k+":"+attrs[k].(string))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// The kinds of operation tagged on connections with the terraform_operation
+// connection attribute. Reads include the refresh of terraform plan and
+// imports, writes are creates, updates and deletes.
+const (
+	operationRead  = "read"
+	operationWrite = "write"
+)
+
+type operationKey struct{}
+
+// tagOperations wraps the functions of resources so that their context says
+// whether they read or write, for operationConfig.
+func tagOperations(resources map[string]*schema.Resource) map[string]*schema.Resource {
+	for _, r := range resources {
+		r.ReadContext = withOperation(operationRead, r.ReadContext)
+		r.CreateContext = withOperation(operationWrite, r.CreateContext)
+		r.UpdateContext = withOperation(operationWrite, r.UpdateContext)
+		r.DeleteContext = withOperation(operationWrite, r.DeleteContext)
+		if r.Importer != nil && r.Importer.StateContext != nil {
+			importState := r.Importer.StateContext
+			r.Importer.StateContext = func(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+				return importState(context.WithValue(ctx, operationKey{}, operationRead), d, meta)
+			}
+		}
+	}
+	return resources
+}
+
+func withOperation(operation string, f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+	if f == nil {
+		return nil
+	}
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		return f(context.WithValue(ctx, operationKey{}, operation), d, meta)
+	}
+}
+
+// operationConfig adds terraform_operation to the connection attributes of
+// cfg when ctx comes from a function wrapped by tagOperations, unless
+// connection_attributes sets it itself.
+func operationConfig(ctx context.Context, cfg *mysql.Config) *mysql.Config {
+	operation, ok := ctx.Value(operationKey{}).(string)
+	if !ok || strings.Contains(","+cfg.ConnectionAttributes, ",terraform_operation:") {
+		return cfg
+	}
+
+	tagged := cfg.Clone()
+	if tagged.ConnectionAttributes != "" {
+		tagged.ConnectionAttributes += ","
+	}
+	tagged.ConnectionAttributes += "terraform_operation:" + operation
+	return tagged
+}
+
+// validateConnectionAttributes rejects what can't be represented in the
+// driver's key:value list.
+func validateConnectionAttributes(v interface{}, path cty.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	for k, value := range v.(map[string]interface{}) {
+		if k == "" || strings.ContainsAny(k, ",:") {
+			diags = append(diags, diag.Errorf("connection_attributes: key %q must not be empty or contain a comma or colon", k)...)
+		}
+		if strings.Contains(value.(string), ",") {
+			diags = append(diags, diag.Errorf("connection_attributes: value of %s must not contain a comma", k)...)
+		}
+	}
+	return diags
+}
+
+// registerServerPubKey registers the server's RSA public key with the driver,
+// so that caching_sha2_password and sha256_password can encrypt the password
+// without TLS and without asking the server for its key first.
+func registerServerPubKey(pemData string) (string, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return "", fmt.Errorf("server_public_key: no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("server_public_key: %s", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("server_public_key: not an RSA public key")
+	}
+
+	// Registered per provider configuration, like the TLS config, so that
+	// aliased providers don't replace each other's key.
+	name := fmt.Sprintf("terraform-provider-mysql-%d", atomic.AddUint64(&serverPubKeySeq, 1))
+	mysql.RegisterServerPubKey(name, rsaKey)
+	return name, nil
+}
+
+// validateProxyURL accepts the socks5 and socks5h URLs makeDialer can use: a
+// host and port, optionally with credentials, which may contain any
+// character percent-encoded.
+func validateProxyURL(v interface{}, k string) (ws []string, errors []error) {
+	u, err := url.Parse(v.(string))
+	if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Hostname() == "" || u.Port() == "" || u.Path != "" {
+		errors = append(errors, fmt.Errorf("%s: The proxy URL is not a valid socks url.", k))
+	}
+	return
+}
+
 func makeDialer(d *schema.ResourceData) (proxy.Dialer, error) {
 	proxyFromEnv := proxy.FromEnvironment()
 	proxyArg := d.Get("proxy").(string)
@@ -258,19 +1137,50 @@ func makeDialer(d *schema.ResourceData) (proxy.Dialer, error) {
 			return nil, err
 		}
 
-		return proxy, nil
+		return bypassNoProxy(proxy), nil
 	}
 
 	return proxyFromEnv, nil
 }
 
+// bypassNoProxy connects directly to the hosts listed in NO_PROXY or
+// no_proxy and through dialer to any other. proxy.FromEnvironment does the
+// same, but only for a proxy given through ALL_PROXY.
+func bypassNoProxy(dialer proxy.Dialer) proxy.Dialer {
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	if noProxy == "" {
+		return dialer
+	}
+
+	perHost := proxy.NewPerHost(dialer, proxy.Direct)
+	perHost.AddFromString(noProxy)
+	return perHost
+}
+
+// checkWritable refuses resource writes when the provider is read-only.
+func checkWritable(meta interface{}) diag.Diagnostics {
+	if meta.(*MySQLConfiguration).ReadOnly {
+		return diag.Errorf("the provider is configured with read_only = true, refusing to modify the server")
+	}
+	return nil
+}
+
 func quoteIdentifier(in string) string {
 	return fmt.Sprintf("`%s`", identQuoteReplacer.Replace(in))
 }
 
-func serverVersion(db *sql.DB) (*version.Version, error) {
+var stringQuoteReplacer = strings.NewReplacer(`\`, `\\`, "'", `\'`)
+
+func quoteString(in string) string {
+	return fmt.Sprintf("'%s'", stringQuoteReplacer.Replace(in))
+}
+
+func serverVersion(ctx context.Context, db *sql.DB) (*version.Version, error) {
 	var versionString string
-	err := db.QueryRow("SELECT @@GLOBAL.innodb_version").Scan(&versionString)
+	err := db.QueryRowContext(ctx, "SELECT @@GLOBAL.innodb_version").Scan(&versionString)
 	if err != nil {
 		return nil, err
 	}
@@ -278,9 +1188,9 @@ func serverVersion(db *sql.DB) (*version.Version, error) {
 	return version.NewVersion(versionString)
 }
 
-func serverVersionString(db *sql.DB) (string, error) {
+func serverVersionString(ctx context.Context, db *sql.DB) (string, error) {
 	var versionString string
-	err := db.QueryRow("SELECT @@GLOBAL.version").Scan(&versionString)
+	err := db.QueryRowContext(ctx, "SELECT @@GLOBAL.version").Scan(&versionString)
 	if err != nil {
 		return "", err
 	}
@@ -288,25 +1198,190 @@ func serverVersionString(db *sql.DB) (string, error) {
 	return versionString, nil
 }
 
-func connectToMySQL(conf *MySQLConfiguration) (*sql.DB, error) {
+const (
+	lockWaitTimeoutErrCode = 1205
+	deadlockErrCode        = 1213
+)
+
+// execRetryInterval is the wait before the second attempt of a write; it
+// doubles with every further attempt.
+var execRetryInterval = 200 * time.Millisecond
+
+// execWithRetry runs a write statement, retrying it when it fails on lock
+// contention with a concurrent operation. Connection errors are retried by
+// connectToMySQL already, everything else is returned right away.
+func execWithRetry(ctx context.Context, meta interface{}, db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	attempts := meta.(*MySQLConfiguration).ExecAttempts
+	interval := execRetryInterval
+
+	for attempt := 1; ; attempt++ {
+		result, err := db.ExecContext(ctx, query, args...)
+		if err == nil || attempt >= attempts || !isLockContentionError(err) {
+			return result, err
+		}
+
+		log.Printf("[WARN] %s (attempt %d of %d), retrying in %s", err, attempt, attempts, interval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+func isLockContentionError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && (mysqlErr.Number == deadlockErrCode || mysqlErr.Number == lockWaitTimeoutErrCode)
+}
 
-	dsn := conf.Config.FormatDSN()
+// connectAs opens a separate connection pool to the same server, through the
+// same tunnel or proxy, but logged in as user with password instead of the
+// provider's credentials, e.g. to check that an account can log in. Unlike
+// connectToMySQL it doesn't retry, so that a failed login is returned right
+// away, and the caller has to close the pool.
+func (conf *MySQLConfiguration) connectAs(ctx context.Context, user string, password string) (*sql.DB, error) {
+	db, err := openDB(conf.configAs(user, password), conf.InitStatements)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// configAs copies the driver configuration with the credentials replaced. No
+// database is selected, as the user may not have access to the provider's.
+func (conf *MySQLConfiguration) configAs(user string, password string) *mysql.Config {
+	c := conf.Config.Clone()
+	c.User = user
+	c.Passwd = password
+	c.DBName = ""
+	return c
+}
+
+// checkHealth runs query and reads its result, so that a proxy which accepts
+// connections but can't route queries anywhere is not taken as ready.
+func checkHealth(ctx context.Context, db *sql.DB, query string) error {
+	if query == "" {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("health_check_query %q failed: %w", query, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("health_check_query %q failed: %w", query, err)
+	}
+	return nil
+}
+
+// openDB returns a pool for cfg. With init_statements the pool is built from
+// a connector that runs them on every new connection, since settings made with
+// SET SESSION only apply to the connection they were run on.
+func openDB(cfg *mysql.Config, initStatements []string) (*sql.DB, error) {
+	// Not through FormatDSN, which leaves out ConnectionAttributes.
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(initStatements) == 0 {
+		return sql.OpenDB(connector), nil
+	}
+
+	return sql.OpenDB(&initConnector{Connector: connector, statements: initStatements}), nil
+}
+
+type initConnector struct {
+	driver.Connector
+	statements []string
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("init_statements: driver connection does not support Exec")
+	}
+
+	for _, stmt := range c.statements {
+		if _, err := execer.ExecContext(ctx, stmt, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("init_statements: %q failed: %s", stmt, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func connectToMySQL(ctx context.Context, conf *MySQLConfiguration) (*sql.DB, error) {
+	if conf.Tunneled && tunnelsClosed.Load() {
+		return nil, fmt.Errorf("the tunnel was closed by mysql_tunnel_close; make it depend on every resource that connects through the tunnel")
+	}
+
+	if !conf.sharePools {
+		return openPool(ctx, conf)
+	}
+
+	operation, _ := ctx.Value(operationKey{}).(string)
+	conf.poolsMu.Lock()
+	defer conf.poolsMu.Unlock()
+	if db, ok := conf.pools[operation]; ok {
+		return db, nil
+	}
+
+	db, err := openPool(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxIdleConns(1)
+	if conf.pools == nil {
+		conf.pools = map[string]*sql.DB{}
+	}
+	conf.pools[operation] = db
+	return db, nil
+}
+
+// openPool opens a pool for conf, tagged by operationConfig, and waits for
+// the server to accept its first connection.
+func openPool(ctx context.Context, conf *MySQLConfiguration) (*sql.DB, error) {
 	var db *sql.DB
 	var err error
+	start := time.Now()
+	cfg := operationConfig(ctx, conf.Config)
 
 	// When provisioning a database server there can often be a lag between
 	// when Terraform thinks it's available and when it is actually available.
 	// This is particularly acute when provisioning a server and then immediately
 	// trying to provision a database on it.
-	retryError := resource.Retry(5*time.Minute, func() *resource.RetryError {
-		db, err = sql.Open("mysql", dsn)
+	retryError := retry.RetryContext(ctx, 5*time.Minute, func() *retry.RetryError {
+		db, err = openDB(cfg, conf.InitStatements)
+		if err != nil {
+			return retry.RetryableError(err)
+		}
+
+		err = db.PingContext(ctx)
 		if err != nil {
-			return resource.RetryableError(err)
+			return retry.RetryableError(err)
 		}
 
-		err = db.Ping()
+		err = checkHealth(ctx, db, conf.HealthCheckQuery)
 		if err != nil {
-			return resource.RetryableError(err)
+			db.Close()
+			return retry.RetryableError(err)
 		}
 
 		return nil
@@ -315,6 +1390,7 @@ func connectToMySQL(conf *MySQLConfiguration) (*sql.DB, error) {
 	if retryError != nil {
 		return nil, fmt.Errorf("Could not connect to server: %s", retryError)
 	}
+	log.Printf("[DEBUG] connected to MySQL at %s in %s", conf.Config.Addr, time.Since(start).Round(time.Millisecond))
 	db.SetConnMaxLifetime(conf.MaxConnLifetime)
 	db.SetMaxOpenConns(conf.MaxOpenConns)
 	return db, nil
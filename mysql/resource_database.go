@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -8,22 +9,29 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 const defaultCharacterSetKeyword = "CHARACTER SET "
+const defaultCharacterSet = "utf8"
 const defaultCollateKeyword = "COLLATE "
 const unknownDatabaseErrCode = 1049
 
+// defaultEncryptionMinVersion is the first MySQL release accepting the
+// DEFAULT ENCRYPTION clause. MariaDB doesn't support it at all.
+const defaultEncryptionMinVersion = "8.0.16"
+
 func resourceDatabase() *schema.Resource {
 	return &schema.Resource{
-		Create: CreateDatabase,
-		Update: UpdateDatabase,
-		Read:   ReadDatabase,
-		Delete: DeleteDatabase,
+		CreateContext: CreateDatabase,
+		UpdateContext: UpdateDatabase,
+		ReadContext:   ReadDatabase,
+		DeleteContext: DeleteDatabase,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			StateContext: schema.ImportStatePassthroughContext,
 		},
+		CustomizeDiff: customizeDatabaseDiff,
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
@@ -31,10 +39,12 @@ func resourceDatabase() *schema.Resource {
 				ForceNew: true,
 			},
 
+			// Computed, as it is implied by default_collation when only
+			// that is set. Defaults to utf8 in customizeDatabaseDiff otherwise.
 			"default_character_set": {
 				Type:     schema.TypeString,
 				Optional: true,
-				Default:  "utf8",
+				Computed: true,
 			},
 
 			"default_collation": {
@@ -42,50 +52,135 @@ func resourceDatabase() *schema.Resource {
 				Optional: true,
 				Default:  "utf8_general_ci",
 			},
+
+			// Computed, so that leaving it unset follows the server's
+			// default_table_encryption instead of forcing 'N'.
+			"default_encryption": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Computed: true,
+			},
+
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"prevent_drop": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"confirm_drop": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 		},
 	}
 }
 
-func CreateDatabase(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func CreateDatabase(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+
+	wantCharset, err := databaseCharset(ctx, db, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var encryption string
+	if !d.GetRawConfig().GetAttr("default_encryption").IsNull() {
+		encryption, err = defaultEncryptionClause(ctx, db, d.Get("default_encryption").(bool))
+		if err != nil {
+			return diag.FromErr(err)
+		}
 	}
 
-	stmtSQL := databaseConfigSQL("CREATE", d)
+	stmtSQL := databaseConfigSQL("CREATE", d, wantCharset, encryption)
 	log.Println("Executing statement:", stmtSQL)
 
-	_, err = db.Exec(stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+
+	if d.Get("adopt_existing").(bool) {
+		// The database may have existed before, in which case IF NOT EXISTS
+		// left it untouched. Only adopt it if it is what we would have
+		// created anyway.
+		var charset, collation string
+		err = db.QueryRowContext(ctx,
+			"SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?",
+			d.Get("name").(string)).Scan(&charset, &collation)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
+		wantCollation := d.Get("default_collation").(string)
+		if (wantCharset != "" && normalizeCharset(charset) != normalizeCharset(wantCharset)) ||
+			(wantCollation != "" && normalizeCharset(collation) != normalizeCharset(wantCollation)) {
+			return diag.Errorf("database %s already exists with character set %s and collation %s, expected %s and %s",
+				d.Get("name").(string), charset, collation, wantCharset, wantCollation)
+		}
 	}
 
 	d.SetId(d.Get("name").(string))
 
-	return ReadDatabase(d, meta)
+	return ReadDatabase(ctx, d, meta)
 }
 
-func UpdateDatabase(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func UpdateDatabase(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	// Flags like prevent_drop only live in state.
+	if !d.HasChanges("default_character_set", "default_collation", "default_encryption") {
+		return ReadDatabase(ctx, d, meta)
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	stmtSQL := databaseConfigSQL("ALTER", d)
+	charset, err := databaseCharset(ctx, db, d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var encryption string
+	if d.HasChange("default_encryption") {
+		encryption, err = defaultEncryptionClause(ctx, db, d.Get("default_encryption").(bool))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	stmtSQL := databaseConfigSQL("ALTER", d, charset, encryption)
 	log.Println("Executing statement:", stmtSQL)
 
-	_, err = db.Exec(stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	return ReadDatabase(d, meta)
+	return ReadDatabase(ctx, d, meta)
 }
 
-func ReadDatabase(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func ReadDatabase(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	// This is kinda flimsy-feeling, since it depends on the formatting
@@ -98,7 +193,7 @@ func ReadDatabase(d *schema.ResourceData, meta interface{}) error {
 
 	log.Println("Executing query:", stmtSQL)
 	var createSQL, _database string
-	err = db.QueryRow(stmtSQL).Scan(&_database, &createSQL)
+	err = db.QueryRowContext(ctx, stmtSQL).Scan(&_database, &createSQL)
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 			if mysqlErr.Number == unknownDatabaseErrCode {
@@ -106,7 +201,7 @@ func ReadDatabase(d *schema.ResourceData, meta interface{}) error {
 				return nil
 			}
 		}
-		return fmt.Errorf("Error during show create database: %s", err)
+		return diag.Errorf("Error during show create database: %s", err)
 	}
 
 	defaultCharset := extractIdentAfter(createSQL, defaultCharacterSetKeyword)
@@ -120,33 +215,48 @@ func ReadDatabase(d *schema.ResourceData, meta interface{}) error {
 		var empty interface{}
 
 		requiredVersion, _ := version.NewVersion("8.0.0")
-		currentVersion, err := serverVersion(db)
+		currentVersion, err := serverVersion(ctx, db)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 
-		serverVersionString, err := serverVersionString(db)
+		serverVersionString, err := serverVersionString(ctx, db)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 
 		// MySQL 8 returns more data in a row.
 		var res error
 		if !strings.Contains(serverVersionString, "MariaDB") && currentVersion.GreaterThan(requiredVersion) {
-			res = db.QueryRow(stmtSQL, defaultCharset).Scan(&defaultCollation, &empty, &empty, &empty, &empty, &empty, &empty)
+			res = db.QueryRowContext(ctx, stmtSQL, defaultCharset).Scan(&defaultCollation, &empty, &empty, &empty, &empty, &empty, &empty)
 		} else {
-			res = db.QueryRow(stmtSQL, defaultCharset).Scan(&defaultCollation, &empty, &empty, &empty, &empty, &empty)
+			res = db.QueryRowContext(ctx, stmtSQL, defaultCharset).Scan(&defaultCollation, &empty, &empty, &empty, &empty, &empty)
 		}
 
 		if res != nil {
 			if res == sql.ErrNoRows {
-				return fmt.Errorf("Charset %s has no default collation", defaultCharset)
+				return diag.Errorf("Charset %s has no default collation", defaultCharset)
 			}
 
-			return fmt.Errorf("Error getting default charset: %s, %s", res, defaultCharset)
+			return diag.Errorf("Error getting default charset: %s, %s", res, defaultCharset)
 		}
 	}
 
+	supported, err := supportsDefaultEncryption(ctx, db)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if supported {
+		var encryption string
+		err = db.QueryRowContext(ctx,
+			"SELECT DEFAULT_ENCRYPTION FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?",
+			name).Scan(&encryption)
+		if err != nil {
+			return diag.Errorf("Error reading default encryption: %s", err)
+		}
+		d.Set("default_encryption", encryption == "YES")
+	}
+
 	d.Set("name", name)
 	d.Set("default_character_set", defaultCharset)
 	d.Set("default_collation", defaultCollation)
@@ -154,26 +264,35 @@ func ReadDatabase(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func DeleteDatabase(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
-	if err != nil {
-		return err
+func DeleteDatabase(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
 	}
 
 	name := d.Id()
+	if d.Get("prevent_drop").(bool) && !d.Get("confirm_drop").(bool) {
+		return diag.Errorf("database %s has prevent_drop set; set confirm_drop = true (or prevent_drop = false) and apply before destroying it", name)
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	stmtSQL := "DROP DATABASE " + quoteIdentifier(name)
 	log.Println("Executing statement:", stmtSQL)
 
-	_, err = db.Exec(stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
 	if err == nil {
 		d.SetId("")
 	}
-	return err
+	return diag.FromErr(err)
 }
 
-func databaseConfigSQL(verb string, d *schema.ResourceData) string {
+// databaseConfigSQL builds the CREATE or ALTER DATABASE statement.
+// encryptionClause is appended as is and may be empty.
+func databaseConfigSQL(verb string, d *schema.ResourceData, defaultCharset string, encryptionClause string) string {
 	name := d.Get("name").(string)
-	defaultCharset := d.Get("default_character_set").(string)
 	defaultCollation := d.Get("default_collation").(string)
 
 	var defaultCharsetClause string
@@ -186,15 +305,99 @@ func databaseConfigSQL(verb string, d *schema.ResourceData) string {
 		defaultCollationClause = defaultCollateKeyword + quoteIdentifier(defaultCollation)
 	}
 
+	var ifNotExists string
+	if verb == "CREATE" && d.Get("adopt_existing").(bool) {
+		ifNotExists = "IF NOT EXISTS "
+	}
+
 	return fmt.Sprintf(
-		"%s DATABASE %s %s %s",
+		"%s DATABASE %s%s %s %s %s",
 		verb,
+		ifNotExists,
 		quoteIdentifier(name),
 		defaultCharsetClause,
 		defaultCollationClause,
+		encryptionClause,
 	)
 }
 
+// defaultEncryptionClause returns the DEFAULT ENCRYPTION clause for enabled,
+// failing on servers that don't understand it rather than silently dropping
+// an encryption requirement.
+func defaultEncryptionClause(ctx context.Context, db *sql.DB, enabled bool) (string, error) {
+	supported, err := supportsDefaultEncryption(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	if !supported {
+		return "", fmt.Errorf("default_encryption requires MySQL %s or newer", defaultEncryptionMinVersion)
+	}
+
+	if enabled {
+		return "DEFAULT ENCRYPTION 'Y'", nil
+	}
+	return "DEFAULT ENCRYPTION 'N'", nil
+}
+
+func supportsDefaultEncryption(ctx context.Context, db *sql.DB) (bool, error) {
+	versionString, err := serverVersionString(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	if strings.Contains(versionString, "MariaDB") {
+		return false, nil
+	}
+
+	currentVersion, err := serverVersion(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	requiredVersion, _ := version.NewVersion(defaultEncryptionMinVersion)
+	return !currentVersion.LessThan(requiredVersion), nil
+}
+
+// customizeDatabaseDiff fills in default_character_set when it isn't
+// configured: the old utf8 default if default_collation isn't configured
+// either, otherwise the charset implied by the collation. The latter is only
+// known after apply when the collation changes, and is left alone otherwise so
+// that a collation-only configuration doesn't show a diff on the charset.
+func customizeDatabaseDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if !d.GetRawConfig().GetAttr("default_character_set").IsNull() {
+		return nil
+	}
+
+	if d.GetRawConfig().GetAttr("default_collation").IsNull() {
+		if normalizeCharset(d.Get("default_character_set").(string)) != normalizeCharset(defaultCharacterSet) {
+			return d.SetNew("default_character_set", defaultCharacterSet)
+		}
+		return nil
+	}
+
+	if d.HasChange("default_collation") {
+		return d.SetNewComputed("default_character_set")
+	}
+	return nil
+}
+
+// databaseCharset returns the character set to create or alter the database
+// with. If it isn't known, it is looked up from the collation, since stating
+// a different one would fail.
+func databaseCharset(ctx context.Context, db *sql.DB, d *schema.ResourceData) (string, error) {
+	charset := d.Get("default_character_set").(string)
+	collation := d.Get("default_collation").(string)
+	if charset != "" || collation == "" {
+		return charset, nil
+	}
+
+	err := db.QueryRowContext(ctx,
+		"SELECT CHARACTER_SET_NAME FROM information_schema.COLLATIONS WHERE COLLATION_NAME = ?",
+		collation).Scan(&charset)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("unknown collation %s", collation)
+	}
+	return charset, err
+}
+
 func extractIdentAfter(sql string, keyword string) string {
 	charsetIndex := strings.Index(sql, keyword)
 	if charsetIndex != -1 {
@@ -206,3 +409,13 @@ func extractIdentAfter(sql string, keyword string) string {
 
 	return ""
 }
+
+// normalizeCharset maps the utf8 alias to utf8mb3, which is how MySQL 8
+// reports it, in both character set and collation names.
+func normalizeCharset(name string) string {
+	name = strings.ToLower(name)
+	if name == "utf8" || strings.HasPrefix(name, "utf8_") {
+		return "utf8mb3" + strings.TrimPrefix(name, "utf8")
+	}
+	return name
+}
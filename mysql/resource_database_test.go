@@ -1,14 +1,16 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/go-sql-driver/mysql"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func TestAccDatabase(t *testing.T) {
@@ -57,7 +59,7 @@ func TestAccDatabase_collationChange(t *testing.T) {
 			},
 			{
 				PreConfig: func() {
-					db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+					db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 					if err != nil {
 						return
 					}
@@ -73,6 +75,148 @@ func TestAccDatabase_collationChange(t *testing.T) {
 	})
 }
 
+func TestAccDatabase_collationOnly(t *testing.T) {
+	dbName := "terraform_acceptance_test_collation"
+	resourceName := "mysql_database.test"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccDatabaseCheckDestroy(dbName),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDatabaseConfig_collation(dbName, "latin1_bin"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDatabaseCheck_full(resourceName, dbName, "latin1", "latin1_bin"),
+				),
+			},
+			{
+				Config:   testAccDatabaseConfig_collation(dbName, "latin1_bin"),
+				PlanOnly: true,
+			},
+			{
+				Config: testAccDatabaseConfig_collation(dbName, "latin1_swedish_ci"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDatabaseCheck_full(resourceName, dbName, "latin1", "latin1_swedish_ci"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccDatabase_adoptExisting(t *testing.T) {
+	dbName := "terraform_acceptance_test_adopt"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s CHARACTER SET utf8 COLLATE utf8_bin", dbName)); err != nil {
+				t.Fatal(err)
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccDatabaseCheckDestroy(dbName),
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccDatabaseConfig_adopt(dbName, "latin1", "latin1_bin"),
+				ExpectError: regexp.MustCompile("already exists with character set"),
+			},
+			{
+				Config: testAccDatabaseConfig_adopt(dbName, "utf8", "utf8_bin"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccDatabaseCheck_basic("mysql_database.test", dbName),
+					resource.TestCheckResourceAttr("mysql_database.test", "adopt_existing", "true"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccDatabase_preventDrop(t *testing.T) {
+	dbName := "terraform_acceptance_test_prevent_drop"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccDatabaseCheckDestroy(dbName),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDatabaseConfig_preventDrop(dbName, false),
+				Check:  testAccDatabaseCheck_basic("mysql_database.test", dbName),
+			},
+			{
+				Config:      testAccDatabaseConfig_preventDrop(dbName, false),
+				Destroy:     true,
+				ExpectError: regexp.MustCompile("has prevent_drop set"),
+			},
+			{
+				Config: testAccDatabaseConfig_preventDrop(dbName, true),
+				Check:  resource.TestCheckResourceAttr("mysql_database.test", "confirm_drop", "true"),
+			},
+		},
+	})
+}
+
+func TestAccDatabase_defaultEncryption(t *testing.T) {
+	dbName := "terraform_acceptance_test_encryption"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				return
+			}
+
+			supported, err := supportsDefaultEncryption(context.Background(), db)
+			if err != nil {
+				return
+			}
+			if !supported {
+				t.Skip("DEFAULT ENCRYPTION requires MySQL " + defaultEncryptionMinVersion + "+")
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccDatabaseCheckDestroy(dbName),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDatabaseConfig_encryption(dbName, false),
+				Check:  resource.TestCheckResourceAttr("mysql_database.test", "default_encryption", "false"),
+			},
+			{
+				Config: testAccDatabaseConfig_encryption(dbName, true),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("mysql_database.test", "default_encryption", "true"),
+					testAccDatabaseCheck_encryption(dbName, "YES"),
+				),
+			},
+		},
+	})
+}
+
+func testAccDatabaseCheck_encryption(name string, want string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+		if err != nil {
+			return err
+		}
+
+		var encryption string
+		err = db.QueryRow("SELECT DEFAULT_ENCRYPTION FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", name).Scan(&encryption)
+		if err != nil {
+			return fmt.Errorf("error reading database: %s", err)
+		}
+		if encryption != want {
+			return fmt.Errorf("database default encryption is %s, expected %s", encryption, want)
+		}
+		return nil
+	}
+}
+
 func testAccDatabaseCheck_basic(rn string, name string) resource.TestCheckFunc {
 	return testAccDatabaseCheck_full(rn, name, "utf8", "utf8_bin")
 }
@@ -88,7 +232,7 @@ func testAccDatabaseCheck_full(rn string, name string, charset string, collation
 			return fmt.Errorf("database id not set")
 		}
 
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -112,7 +256,7 @@ func testAccDatabaseCheck_full(rn string, name string, charset string, collation
 
 func testAccDatabaseCheckDestroy(name string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -145,3 +289,42 @@ resource "mysql_database" "test" {
     default_collation = "%s"
 }`, name, charset, collation)
 }
+
+func testAccDatabaseConfig_collation(name string, collation string) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+    name = "%s"
+    default_collation = "%s"
+}`, name, collation)
+}
+
+func testAccDatabaseConfig_adopt(name string, charset string, collation string) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+    name = "%s"
+    default_character_set = "%s"
+    default_collation = "%s"
+    adopt_existing = true
+}`, name, charset, collation)
+}
+
+func testAccDatabaseConfig_preventDrop(name string, confirmDrop bool) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+    name = "%s"
+    default_character_set = "utf8"
+    default_collation = "utf8_bin"
+    prevent_drop = true
+    confirm_drop = %t
+}`, name, confirmDrop)
+}
+
+func testAccDatabaseConfig_encryption(name string, encryption bool) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+    name = "%s"
+    default_character_set = "utf8mb4"
+    default_collation = "utf8mb4_general_ci"
+    default_encryption = %t
+}`, name, encryption)
+}
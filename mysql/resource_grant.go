@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -8,19 +9,29 @@ import (
 	"strings"
 
 	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 const nonexistingGrantErrCode = 1141
 
+// Object types a grant can apply to. A table and a routine may share a name,
+// so the type has to be part of the grant target.
+const (
+	grantObjectTable     = "TABLE"
+	grantObjectProcedure = "PROCEDURE"
+	grantObjectFunction  = "FUNCTION"
+)
+
 func resourceGrant() *schema.Resource {
 	return &schema.Resource{
-		Create: CreateGrant,
-		Update: nil,
-		Read:   ReadGrant,
-		Delete: DeleteGrant,
+		CreateContext: CreateGrant,
+		UpdateContext: nil,
+		ReadContext:   ReadGrant,
+		DeleteContext: DeleteGrant,
 		Importer: &schema.ResourceImporter{
-			State: ImportGrant,
+			StateContext: ImportGrant,
 		},
 
 		Schema: map[string]*schema.Schema{
@@ -44,12 +55,33 @@ func resourceGrant() *schema.Resource {
 				ForceNew:      true,
 				Default:       "localhost",
 				ConflictsWith: []string{"role"},
+				StateFunc:     func(v interface{}) string { return normalizeHost(v.(string)) },
+				// Grants in state from before the host was normalized.
+				DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+					return normalizeHost(old) == normalizeHost(new)
+				},
 			},
 
 			"database": {
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"database", "proxy_user"},
+			},
+
+			"proxy_user": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ExactlyOneOf:  []string{"database", "proxy_user"},
+				ConflictsWith: []string{"roles"},
+			},
+
+			"proxy_host": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				RequiredWith: []string{"proxy_user"},
 			},
 
 			"table": {
@@ -59,12 +91,39 @@ func resourceGrant() *schema.Resource {
 				Default:  "*",
 			},
 
+			"object_type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      grantObjectTable,
+				ValidateFunc: validation.StringInSlice([]string{grantObjectTable, grantObjectProcedure, grantObjectFunction}, false),
+				// Grants created before object_type existed are table grants.
+				DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+					return old == "" && new == grantObjectTable
+				},
+			},
+
 			"privileges": {
 				Type:     schema.TypeSet,
 				Optional: true,
 				ForceNew: true,
-				Elem:     &schema.Schema{Type: schema.TypeString},
-				Set:      schema.HashString,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validatePrivilege,
+				},
+				Set: schema.HashString,
+			},
+
+			"excluded_privileges": {
+				Type:          schema.TypeSet,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"roles", "proxy_user"},
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validatePrivilege,
+				},
+				Set: schema.HashString,
 			},
 
 			"roles": {
@@ -118,8 +177,8 @@ func formatTableName(table string) string {
 }
 
 func userOrRole(user string, host string, role string, hasRoles bool) (string, bool, error) {
-	if len(user) > 0 && len(host) > 0 {
-		return fmt.Sprintf("'%s'@'%s'", user, host), false, nil
+	if len(user) > 0 {
+		return fmt.Sprintf("%s@%s", quoteString(user), quoteString(normalizeHost(host))), false, nil
 	} else if len(role) > 0 {
 		if !hasRoles {
 			return "", false, fmt.Errorf("Roles are only supported on MySQL 8 and above")
@@ -131,8 +190,8 @@ func userOrRole(user string, host string, role string, hasRoles bool) (string, b
 	}
 }
 
-func supportsRoles(db *sql.DB) (bool, error) {
-	currentVersion, err := serverVersion(db)
+func supportsRoles(ctx context.Context, db *sql.DB) (bool, error) {
+	currentVersion, err := serverVersion(ctx, db)
 	if err != nil {
 		return false, err
 	}
@@ -142,15 +201,19 @@ func supportsRoles(db *sql.DB) (bool, error) {
 	return hasRoles, nil
 }
 
-func CreateGrant(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func CreateGrant(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	hasRoles, err := supportsRoles(db)
+	hasRoles, err := supportsRoles(ctx, db)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	var (
@@ -161,17 +224,39 @@ func CreateGrant(d *schema.ResourceData, meta interface{}) error {
 	hasPrivs := false
 	rolesGranted := 0
 	if attr, ok := d.GetOk("privileges"); ok {
+		supported, err := supportedPrivileges(ctx, db)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		for _, privilege := range attr.(*schema.Set).List() {
+			if err := supported.check(privilege.(string)); err != nil {
+				return diag.Errorf("%s on this server", err)
+			}
+		}
+		if err := checkProxyGrant(attr.(*schema.Set), d.Get("proxy_user").(string)); err != nil {
+			return diag.FromErr(err)
+		}
+		excluded := d.Get("excluded_privileges").(*schema.Set)
+		if err := checkExcludedPrivileges(attr.(*schema.Set), excluded, d.Get("grant").(bool)); err != nil {
+			return diag.FromErr(err)
+		}
+		for _, privilege := range excluded.List() {
+			if err := supported.check(privilege.(string)); err != nil {
+				return diag.Errorf("excluded_privileges: %s on this server", err)
+			}
+		}
+
 		privilegesOrRoles = flattenList(attr.(*schema.Set).List(), "%s")
 		hasPrivs = true
 	} else if attr, ok := d.GetOk("roles"); ok {
 		if !hasRoles {
-			return fmt.Errorf("Roles are only supported on MySQL 8 and above")
+			return diag.Errorf("Roles are only supported on MySQL 8 and above")
 		}
 		listOfRoles := attr.(*schema.Set).List()
 		rolesGranted = len(listOfRoles)
 		privilegesOrRoles = flattenList(listOfRoles, "'%s'")
 	} else {
-		return fmt.Errorf("One of privileges or roles is required")
+		return diag.Errorf("One of privileges or roles is required")
 	}
 
 	user := d.Get("user").(string)
@@ -180,15 +265,21 @@ func CreateGrant(d *schema.ResourceData, meta interface{}) error {
 
 	userOrRole, isRole, err := userOrRole(user, host, role, hasRoles)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
+	target, err := grantTarget(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 	database := formatDatabaseName(d.Get("database").(string))
-
-	table := formatTableName(d.Get("table").(string))
+	proxyTarget, isProxy := proxyGrantTarget(d)
+	if isProxy {
+		target = proxyTarget
+	}
 
 	if (!isRole || hasPrivs) && rolesGranted == 0 {
-		grantOn = fmt.Sprintf(" ON %s.%s", database, table)
+		grantOn = fmt.Sprintf(" ON %s", target)
 	}
 
 	stmtSQL := fmt.Sprintf("GRANT %s%s TO %s",
@@ -196,8 +287,9 @@ func CreateGrant(d *schema.ResourceData, meta interface{}) error {
 		grantOn,
 		userOrRole)
 
-	// MySQL 8+ doesn't allow REQUIRE on a GRANT statement.
-	if !hasRoles && d.Get("tls_option").(string) != "" {
+	// MySQL 8+ doesn't allow REQUIRE on a GRANT statement, and GRANT PROXY
+	// never does.
+	if !hasRoles && !isProxy && d.Get("tls_option").(string) != "" {
 		stmtSQL += fmt.Sprintf(" REQUIRE %s", d.Get("tls_option").(string))
 	}
 
@@ -206,30 +298,44 @@ func CreateGrant(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	log.Println("Executing statement:", stmtSQL)
-	_, err = db.Exec(stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
 	if err != nil {
-		return fmt.Errorf("Error running SQL (%s): %s", stmtSQL, err)
+		return diag.Errorf("Error running SQL (%s): %s", stmtSQL, err)
 	}
 
-	id := fmt.Sprintf("%s@%s:%s", user, host, database)
+	// MySQL has no GRANT ALL EXCEPT, so the exclusions are revoked right
+	// after granting everything.
+	if excluded := d.Get("excluded_privileges").(*schema.Set); excluded.Len() > 0 {
+		stmtSQL = fmt.Sprintf("REVOKE %s ON %s FROM %s", flattenList(excluded.List(), "%s"), target, userOrRole)
+		log.Println("Executing statement:", stmtSQL)
+		if _, err := execWithRetry(ctx, meta, db, stmtSQL); err != nil {
+			return diag.Errorf("Error running SQL (%s): %s", stmtSQL, err)
+		}
+	}
+
+	on := database
+	if isProxy {
+		on = proxyTarget
+	}
+	id := fmt.Sprintf("%s@%s:%s", user, normalizeHost(host), on)
 	if isRole {
-		id = fmt.Sprintf("%s:%s", role, database)
+		id = fmt.Sprintf("%s:%s", role, on)
 	}
 
 	d.SetId(id)
 
-	return ReadGrant(d, meta)
+	return ReadGrant(ctx, d, meta)
 }
 
-func ReadGrant(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func ReadGrant(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	hasRoles, err := supportsRoles(db)
+	hasRoles, err := supportsRoles(ctx, db)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	userOrRole, _, err := userOrRole(
@@ -238,35 +344,80 @@ func ReadGrant(d *schema.ResourceData, meta interface{}) error {
 		d.Get("role").(string),
 		hasRoles)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	sql := fmt.Sprintf("SHOW GRANTS FOR %s", userOrRole)
 
 	log.Println("[DEBUG] SQL:", sql)
 
-	_, err = db.Exec(sql)
+	rows, err := db.QueryContext(ctx, sql)
 	if err != nil {
 		log.Printf("[WARN] GRANT not found for %s - removing from state", userOrRole)
 		d.SetId("")
+		return nil
 	}
+	defer rows.Close()
 
+	proxyTarget, isProxy := proxyGrantTarget(d)
+	if !isProxy && d.Get("privileges").(*schema.Set).Len() == 0 {
+		return nil
+	}
+
+	// MySQL 8 lists dynamic privileges on a line of their own, so every line
+	// on the target is looked at for the privileges held.
+	found := false
+	held := map[string]bool{}
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			return diag.FromErr(err)
+		}
+		if isProxy && isProxyGrantOf(grant, proxyTarget) {
+			return nil
+		}
+		if !isProxy && isGrantOn(grant, d.Get("object_type").(string), d.Get("database").(string), d.Get("table").(string)) {
+			found = true
+			for _, privilege := range grantedPrivileges(grant) {
+				held[privilege] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return diag.FromErr(err)
+	}
+
+	if found {
+		// An excluded privilege granted since then is dropped from the
+		// exclusions, so that the plan replaces the grant to revoke it again.
+		if excluded := d.Get("excluded_privileges").(*schema.Set); excluded.Len() > 0 {
+			d.Set("excluded_privileges", stillExcluded(excluded, held))
+		}
+		return nil
+	}
+
+	if isProxy {
+		log.Printf("[WARN] PROXY grant on %s not found for %s - removing from state", proxyTarget, userOrRole)
+	} else {
+		log.Printf("[WARN] %s grant on %s.%s not found for %s - removing from state", d.Get("object_type"), d.Get("database"), d.Get("table"), userOrRole)
+	}
+	d.SetId("")
 	return nil
 }
 
-func DeleteGrant(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
-	if err != nil {
-		return err
+func DeleteGrant(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
 	}
 
-	database := formatDatabaseName(d.Get("database").(string))
-
-	table := formatTableName(d.Get("table").(string))
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-	hasRoles, err := supportsRoles(db)
+	hasRoles, err := supportsRoles(ctx, db)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	userOrRole, isRole, err := userOrRole(
@@ -275,45 +426,48 @@ func DeleteGrant(d *schema.ResourceData, meta interface{}) error {
 		d.Get("role").(string),
 		hasRoles)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	roles := d.Get("roles").(*schema.Set)
 	privileges := d.Get("privileges").(*schema.Set)
 
-	var sql string
-	if !isRole && len(roles.List()) == 0 {
-		sql = fmt.Sprintf("REVOKE GRANT OPTION ON %s.%s FROM %s",
-			database,
-			table,
-			userOrRole)
-
-		log.Printf("[DEBUG] SQL: %s", sql)
-		_, err = db.Exec(sql)
-		if err != nil {
-			return fmt.Errorf("error revoking GRANT (%s): %s", sql, err)
-		}
+	target, err := grantTarget(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	proxyTarget, isProxy := proxyGrantTarget(d)
+	if isProxy {
+		target = proxyTarget
 	}
 
-	whatToRevoke := fmt.Sprintf("ALL ON %s.%s", database, table)
+	// Only revoke what this resource granted, other grants on the same user
+	// and scope may be managed elsewhere.
+	var whatToRevoke string
 	if len(roles.List()) > 0 {
 		whatToRevoke = flattenList(roles.List(), "'%s'")
 	} else if len(privileges.List()) > 0 {
 		privilegeList := flattenList(privileges.List(), "%s")
-		whatToRevoke = fmt.Sprintf("%s ON %s.%s", privilegeList, database, table)
+		if !hasRoles && !isRole && !isProxy && d.Get("grant").(bool) {
+			privilegeList += ", GRANT OPTION"
+		}
+		whatToRevoke = fmt.Sprintf("%s ON %s", privilegeList, target)
+	} else {
+		log.Printf("[WARN] no privileges or roles recorded for %s, nothing to revoke", userOrRole)
+		return nil
 	}
 
-	sql = fmt.Sprintf("REVOKE %s FROM %s", whatToRevoke, userOrRole)
+	sql := fmt.Sprintf("REVOKE %s FROM %s", whatToRevoke, userOrRole)
 	log.Printf("[DEBUG] SQL: %s", sql)
-	_, err = db.Exec(sql)
+	_, err = execWithRetry(ctx, meta, db, sql)
 	if err != nil {
-		return fmt.Errorf("error revoking ALL (%s): %s", sql, err)
+		return diag.Errorf("error revoking GRANT (%s): %s", sql, err)
 	}
 
 	return nil
 }
 
-func ImportGrant(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+func ImportGrant(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
 	// terraform import mysql_grant.user user@host:database
 	id := d.Id()
 	userHostDB := strings.SplitN(id, "@", 2)
@@ -327,23 +481,22 @@ func ImportGrant(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceDa
 	if len(hostDB) != 2 {
 		return nil, fmt.Errorf("wrong ID format %s (expected USER@HOST:DATABASE)", d.Id())
 	}
-	host := hostDB[0]
+	host := normalizeHost(hostDB[0])
 	database := hostDB[1]
 
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
 		return nil, err
 	}
 
-	sql := fmt.Sprintf("SHOW GRANTS FOR '%s'@'%s'", user, host)
-	rows, err := db.Query(sql)
+	sql := fmt.Sprintf("SHOW GRANTS FOR %s@%s", quoteString(user), quoteString(host))
+	rows, err := db.QueryContext(ctx, sql)
 
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
-	re := regexp.MustCompile(`^GRANT (.+) ON (.+?)\.(.+?) TO`)
 	results := []*schema.ResourceData{}
 
 	for rows.Next() {
@@ -355,24 +508,28 @@ func ImportGrant(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceDa
 			return nil, err
 		}
 
-		m := re.FindStringSubmatch(grant)
+		// Roles granted to the user and PROXY grants are not database grants.
+		if isProxyGrantOf(grant, "") || !strings.Contains(grant, " ON ") {
+			continue
+		}
 
-		if len(m) != 4 {
+		privilegesStr, objectType, grantDatabase, table, ok := parseGrant(grant)
+		if !ok {
 			return nil, fmt.Errorf("failed to parse grant statement: %s", grant)
 		}
 
-		if database != strings.Trim(m[2], "`") {
+		if database != grantDatabase {
 			continue
 		}
 
-		privileges := splitPrivileges(m[1])
-		table := strings.Trim(m[3], "`")
+		privileges := splitPrivileges(privilegesStr)
 		d := resourceGrant().Data(nil)
 		d.SetId(id)
 		d.Set("user", user)
 		d.Set("host", host)
 		d.Set("database", database)
 		d.Set("table", table)
+		d.Set("object_type", objectType)
 		d.Set("tls_option", "NONE")
 		d.Set("privileges", privileges)
 
@@ -386,11 +543,243 @@ func ImportGrant(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceDa
 	return results, nil
 }
 
+// splitPrivileges splits the privileges of a line of SHOW GRANTS, keeping the
+// column list of a column privilege such as SELECT (`id`, `name`) together.
 func splitPrivileges(privilegesStr string) []string {
 	var privileges []string
 
-	for _, v := range strings.Split(privilegesStr, ",") {
-		privileges = append(privileges, strings.TrimSpace(v))
+	depth, start := 0, 0
+	for i, c := range privilegesStr {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				privileges = append(privileges, strings.TrimSpace(privilegesStr[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(privileges, strings.TrimSpace(privilegesStr[start:]))
+}
+
+// grantTarget returns the ON clause of a privilege grant. Routines are
+// prefixed with their type, as GRANT requires for them.
+func grantTarget(d *schema.ResourceData) (string, error) {
+	database := formatDatabaseName(d.Get("database").(string))
+	table := formatTableName(d.Get("table").(string))
+	if database == "*" && table != "*" {
+		return "", fmt.Errorf("table must be * when database is *, as MySQL has no *.%s scope", table)
+	}
+
+	objectType := d.Get("object_type").(string)
+	if objectType == "" || objectType == grantObjectTable {
+		return fmt.Sprintf("%s.%s", database, table), nil
+	}
+
+	if table == "*" {
+		return "", fmt.Errorf("object_type %s requires table to name the routine", objectType)
+	}
+	return fmt.Sprintf("%s %s.%s", objectType, database, table), nil
+}
+
+var grantRegexp = regexp.MustCompile(`^GRANT (.+?) ON (?:(PROCEDURE|FUNCTION) )?`)
+
+// parseGrant splits a line of SHOW GRANTS into its privileges, object type,
+// database and table, with the identifier quotes removed. The scope is one of
+// *.*, db.* and db.tbl, where only an unquoted * is a wildcard.
+func parseGrant(grant string) (privileges, objectType, database, table string, ok bool) {
+	m := grantRegexp.FindStringSubmatch(grant)
+	if m == nil {
+		return "", "", "", "", false
+	}
+
+	rest := grant[len(m[0]):]
+	database, rest, ok = readGrantIdentifier(rest)
+	if !ok || !strings.HasPrefix(rest, ".") {
+		return "", "", "", "", false
+	}
+	table, rest, ok = readGrantIdentifier(rest[1:])
+	if !ok || !strings.HasPrefix(rest, " TO ") || (database == "*" && table != "*") {
+		return "", "", "", "", false
+	}
+
+	objectType = m[2]
+	if objectType == "" {
+		objectType = grantObjectTable
+	}
+	return m[1], objectType, database, table, true
+}
+
+// readGrantIdentifier reads a possibly backtick-quoted identifier, or *, from
+// the start of s and returns it unquoted along with the remainder of s.
+// Quoted identifiers may contain dots and escape backticks by doubling them.
+func readGrantIdentifier(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, "`") {
+		end := strings.IndexAny(s, ". ")
+		if end == -1 {
+			end = len(s)
+		}
+		return s[:end], s[end:], end > 0
+	}
+
+	var ident strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != '`' {
+			ident.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '`' {
+			ident.WriteByte('`')
+			i++
+			continue
+		}
+		return ident.String(), s[i+1:], true
+	}
+	return "", "", false
+}
+
+// unquoteGrantIdentifier removes the backticks formatDatabaseName accepts
+// around a configured database name.
+func unquoteGrantIdentifier(name string) string {
+	if len(name) > 1 && strings.HasPrefix(name, "`") && strings.HasSuffix(name, "`") {
+		return strings.ReplaceAll(name[1:len(name)-1], "``", "`")
+	}
+	return name
+}
+
+// isGrantOn reports whether a line of SHOW GRANTS applies to the given
+// object, so that a procedure grant is not mistaken for a grant on a table of
+// the same name or vice versa, and a grant on db.* is not mistaken for one on
+// *.* or on a table of db.
+func isGrantOn(grant, objectType, database, table string) bool {
+	_, grantType, grantDatabase, grantTable, ok := parseGrant(grant)
+	if !ok {
+		return false
+	}
+	if objectType == "" {
+		objectType = grantObjectTable
+	}
+	database = unquoteGrantIdentifier(database)
+	if table == "" {
+		table = "*"
+	}
+
+	return grantType == objectType &&
+		strings.ReplaceAll(grantDatabase, `\`, "") == strings.ReplaceAll(database, `\`, "") &&
+		grantTable == table
+}
+
+// proxyGrantTarget returns the account a PROXY grant applies to, quoted for
+// use in GRANT/REVOKE statements.
+func proxyGrantTarget(d *schema.ResourceData) (string, bool) {
+	proxyUser := d.Get("proxy_user").(string)
+	if proxyUser == "" {
+		return "", false
+	}
+
+	// An empty proxy_host normalizes to %, and the host is lowercased as
+	// SHOW GRANTS returns it, like the grantee of userOrRole.
+	proxyHost := normalizeHost(d.Get("proxy_host").(string))
+	return fmt.Sprintf("%s@%s", quoteString(proxyUser), quoteString(proxyHost)), true
+}
+
+// checkProxyGrant makes sure PROXY is only used together with proxy_user and
+// on its own, since GRANT PROXY has a statement form of its own.
+func checkProxyGrant(privileges *schema.Set, proxyUser string) error {
+	hasProxy := false
+	for _, privilege := range privileges.List() {
+		if normalizePrivilege(privilege.(string)) == "PROXY" {
+			hasProxy = true
+		}
+	}
+
+	switch {
+	case hasProxy && proxyUser == "":
+		return fmt.Errorf("the PROXY privilege requires proxy_user")
+	case proxyUser != "" && (!hasProxy || privileges.Len() > 1):
+		return fmt.Errorf("proxy_user requires privileges to be exactly [\"PROXY\"]")
+	}
+	return nil
+}
+
+// checkExcludedPrivileges makes sure excluded_privileges is only combined
+// with privileges = ["ALL"], which is what the exclusions are revoked from.
+func checkExcludedPrivileges(privileges *schema.Set, excluded *schema.Set, withGrant bool) error {
+	if excluded.Len() == 0 {
+		return nil
+	}
+
+	if privileges.Len() != 1 || normalizeAllPrivileges(privileges.List()[0].(string)) != "ALL PRIVILEGES" {
+		return fmt.Errorf("excluded_privileges requires privileges to be exactly [\"ALL\"]")
+	}
+	for _, privilege := range excluded.List() {
+		switch normalizeAllPrivileges(privilege.(string)) {
+		case "ALL PRIVILEGES", "USAGE", "PROXY":
+			return fmt.Errorf("excluded_privileges: %s can't be excluded", privilege)
+		case "GRANT OPTION":
+			if withGrant {
+				return fmt.Errorf("excluded_privileges: GRANT OPTION can't be excluded with grant = true")
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeAllPrivileges normalizes a privilege, treating ALL as its synonym
+// ALL PRIVILEGES, which is how SHOW GRANTS lists it.
+func normalizeAllPrivileges(privilege string) string {
+	privilege = normalizePrivilege(privilege)
+	if privilege == "ALL" {
+		return "ALL PRIVILEGES"
+	}
+	return privilege
+}
+
+// grantedPrivileges returns the normalized privileges of a line of SHOW
+// GRANTS, including GRANT OPTION if it is given WITH GRANT OPTION.
+func grantedPrivileges(grant string) []string {
+	privilegesStr, _, _, _, ok := parseGrant(grant)
+	if !ok {
+		return nil
+	}
+
+	var privileges []string
+	for _, privilege := range splitPrivileges(privilegesStr) {
+		privileges = append(privileges, normalizeAllPrivileges(privilege))
+	}
+	if strings.HasSuffix(grant, " WITH GRANT OPTION") {
+		privileges = append(privileges, "GRANT OPTION")
+	}
+	return privileges
+}
+
+// stillExcluded returns the excluded privileges that are not among the held
+// ones, as returned by grantedPrivileges.
+func stillExcluded(excluded *schema.Set, held map[string]bool) []string {
+	var privileges []string
+	for _, privilege := range excluded.List() {
+		name := normalizeAllPrivileges(privilege.(string))
+		if held[name] || (held["ALL PRIVILEGES"] && name != "GRANT OPTION") {
+			continue
+		}
+		privileges = append(privileges, privilege.(string))
 	}
 	return privileges
 }
+
+// isProxyGrantOf reports whether a line of SHOW GRANTS is a PROXY grant on
+// target, or any PROXY grant if target is empty. MySQL 8 quotes accounts with
+// backticks, older servers with single quotes.
+func isProxyGrantOf(grant string, target string) bool {
+	if !strings.HasPrefix(grant, "GRANT PROXY ON ") {
+		return false
+	}
+	if target == "" {
+		return true
+	}
+	on := strings.ReplaceAll(strings.TrimPrefix(grant, "GRANT PROXY ON "), "`", "'")
+	return strings.HasPrefix(on, target+" TO ")
+}
@@ -1,17 +1,20 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func TestAccGrant(t *testing.T) {
@@ -46,6 +49,271 @@ func TestAccGrant(t *testing.T) {
 	})
 }
 
+func TestAccGrant_destroyKeepsOtherGrants(t *testing.T) {
+	dbName := fmt.Sprintf("tf-test-%d", rand.Intn(100))
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccGrantCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccGrantConfig_twoGrants(dbName, true),
+				Check: resource.ComposeTestCheckFunc(
+					testAccPrivilegeExists("mysql_grant.select", "SELECT"),
+					testAccPrivilegeExists("mysql_grant.insert", "INSERT"),
+				),
+			},
+			{
+				Config: testAccGrantConfig_twoGrants(dbName, false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccPrivilegeExists("mysql_grant.insert", "INSERT"),
+					testAccPrivilegeExists("mysql_grant.insert", "GRANT OPTION"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccGrant_proxy(t *testing.T) {
+	dbName := fmt.Sprintf("tf-test-%d", rand.Intn(100))
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccGrantCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccGrantConfig_proxy(dbName),
+				Check: resource.ComposeTestCheckFunc(
+					testAccPrivilegeExists("mysql_grant.test", "PROXY"),
+					resource.TestCheckResourceAttr("mysql_grant.test", "proxy_user", fmt.Sprintf("app-%s", dbName)),
+					resource.TestCheckResourceAttr("mysql_grant.test", "proxy_host", "example.com"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccGrant_excludedPrivileges(t *testing.T) {
+	dbName := fmt.Sprintf("tf-test-%d", rand.Intn(100))
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccGrantCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccGrantConfig_excludedPrivileges(dbName),
+				Check: resource.ComposeTestCheckFunc(
+					testAccPrivilegeExists("mysql_grant.test", "SELECT"),
+					testAccPrivilegeExists("mysql_grant.test", "CREATE VIEW"),
+					resource.TestCheckResourceAttr("mysql_grant.test", "excluded_privileges.#", "2"),
+				),
+			},
+			{
+				Config:   testAccGrantConfig_excludedPrivileges(dbName),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestCheckExcludedPrivileges(t *testing.T) {
+	set := func(privileges ...interface{}) *schema.Set {
+		return schema.NewSet(schema.HashString, privileges)
+	}
+
+	cases := []struct {
+		privileges *schema.Set
+		excluded   *schema.Set
+		withGrant  bool
+		ok         bool
+	}{
+		{set("SELECT"), set(), false, true},
+		{set("ALL"), set("DROP", "GRANT OPTION"), false, true},
+		{set("all privileges"), set("DROP"), false, true},
+		{set("SELECT"), set("DROP"), false, false},
+		{set("ALL", "SELECT"), set("DROP"), false, false},
+		{set("ALL"), set("USAGE"), false, false},
+		{set("ALL"), set("GRANT OPTION"), true, false},
+	}
+
+	for _, c := range cases {
+		err := checkExcludedPrivileges(c.privileges, c.excluded, c.withGrant)
+		if (err == nil) != c.ok {
+			t.Errorf("%v except %v: got %v, want ok %t", c.privileges.List(), c.excluded.List(), err, c.ok)
+		}
+	}
+}
+
+func TestStillExcluded(t *testing.T) {
+	excluded := schema.NewSet(schema.HashString, []interface{}{"DROP", "Create View", "GRANT OPTION"})
+
+	cases := []struct {
+		grants []string
+		want   []string
+	}{
+		{[]string{"GRANT SELECT, INSERT ON `app`.* TO `jdoe`@`%`"}, []string{"Create View", "DROP", "GRANT OPTION"}},
+		{[]string{"GRANT SELECT, CREATE VIEW ON `app`.* TO `jdoe`@`%` WITH GRANT OPTION"}, []string{"DROP"}},
+		{[]string{"GRANT ALL PRIVILEGES ON `app`.* TO `jdoe`@`%`"}, []string{"GRANT OPTION"}},
+		{[]string{"GRANT SELECT ON *.* TO `jdoe`@`%`", "GRANT DROP ON *.* TO `jdoe`@`%`"}, []string{"Create View", "GRANT OPTION"}},
+	}
+
+	for _, c := range cases {
+		held := map[string]bool{}
+		for _, grant := range c.grants {
+			for _, privilege := range grantedPrivileges(grant) {
+				held[privilege] = true
+			}
+		}
+		got := stillExcluded(excluded, held)
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%v: got %v, want %v", c.grants, got, c.want)
+		}
+	}
+}
+
+func TestIsProxyGrantOf(t *testing.T) {
+	cases := []struct {
+		grant  string
+		target string
+		want   bool
+	}{
+		{"GRANT PROXY ON 'app'@'%' TO 'jdoe'@'example.com'", "'app'@'%'", true},
+		{"GRANT PROXY ON `app`@`%` TO `jdoe`@`example.com`", "'app'@'%'", true},
+		{"GRANT PROXY ON ``@`` TO `root`@`localhost` WITH GRANT OPTION", "", true},
+		{"GRANT PROXY ON 'app2'@'%' TO 'jdoe'@'example.com'", "'app'@'%'", false},
+		{"GRANT SELECT ON `db`.* TO `jdoe`@`example.com`", "", false},
+	}
+
+	for _, c := range cases {
+		if got := isProxyGrantOf(c.grant, c.target); got != c.want {
+			t.Errorf("%s (%s): got %t, want %t", c.grant, c.target, got, c.want)
+		}
+	}
+}
+
+func TestProxyGrantTarget(t *testing.T) {
+	cases := []struct {
+		user string
+		host string
+		want string
+	}{
+		{"app", "", "'app'@'%'"},
+		{"app", "LOCALHOST", "'app'@'localhost'"},
+		{"o'brien", "%", "'o\\'brien'@'%'"},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceGrant().Schema, map[string]interface{}{
+			"user":       "jdoe",
+			"database":   "*",
+			"privileges": []interface{}{"PROXY"},
+			"proxy_user": c.user,
+			"proxy_host": c.host,
+		})
+		got, ok := proxyGrantTarget(d)
+		if !ok || got != c.want {
+			t.Errorf("%s@%s: got %s, want %s", c.user, c.host, got, c.want)
+		}
+	}
+}
+
+func TestIsGrantOn(t *testing.T) {
+	cases := []struct {
+		grant      string
+		objectType string
+		database   string
+		table      string
+		want       bool
+	}{
+		{"GRANT SELECT ON `app`.`report` TO `jdoe`@`%`", "TABLE", "app", "report", true},
+		{"GRANT SELECT ON `app`.`report` TO `jdoe`@`%`", "PROCEDURE", "app", "report", false},
+		{"GRANT EXECUTE ON PROCEDURE `app`.`report` TO `jdoe`@`%`", "PROCEDURE", "app", "report", true},
+		{"GRANT EXECUTE ON PROCEDURE `app`.`report` TO `jdoe`@`%`", "TABLE", "app", "report", false},
+		{"GRANT EXECUTE ON FUNCTION `app`.`report` TO `jdoe`@`%`", "PROCEDURE", "app", "report", false},
+		{"GRANT SELECT, UPDATE ON `app`.* TO 'jdoe'@'%'", "TABLE", "app", "*", true},
+		{"GRANT SELECT ON `app\\_db`.* TO `jdoe`@`%`", "TABLE", "app_db", "", true},
+		{"GRANT SELECT ON `app`.* TO `jdoe`@`%`", "TABLE", "`app`", "*", true},
+		{"GRANT PROXY ON 'app'@'%' TO 'jdoe'@'%'", "TABLE", "app", "*", false},
+	}
+
+	for _, c := range cases {
+		if got := isGrantOn(c.grant, c.objectType, c.database, c.table); got != c.want {
+			t.Errorf("%s (%s %s.%s): got %t, want %t", c.grant, c.objectType, c.database, c.table, got, c.want)
+		}
+	}
+}
+
+func TestIsGrantOn_scope(t *testing.T) {
+	grants := map[string]string{
+		"global":   "GRANT ALL PRIVILEGES ON *.* TO `jdoe`@`%`",
+		"database": "GRANT ALL PRIVILEGES ON `app`.* TO `jdoe`@`%`",
+		"table":    "GRANT ALL PRIVILEGES ON `app`.`report` TO `jdoe`@`%`",
+	}
+	configs := map[string][2]string{
+		"global":   {"*", "*"},
+		"database": {"app", "*"},
+		"table":    {"app", "report"},
+	}
+
+	for grantScope, grant := range grants {
+		for configScope, config := range configs {
+			want := grantScope == configScope
+			if got := isGrantOn(grant, grantObjectTable, config[0], config[1]); got != want {
+				t.Errorf("%s grant vs %s config: got %t, want %t", grantScope, configScope, got, want)
+			}
+		}
+	}
+}
+
+func TestParseGrant(t *testing.T) {
+	cases := []struct {
+		grant    string
+		database string
+		table    string
+		ok       bool
+	}{
+		{"GRANT USAGE ON *.* TO `jdoe`@`%`", "*", "*", true},
+		{"GRANT SELECT ON `app`.* TO `jdoe`@`%` WITH GRANT OPTION", "app", "*", true},
+		{"GRANT SELECT ON app.report TO 'jdoe'@'%'", "app", "report", true},
+		{"GRANT SELECT ON `my.app`.`re``port` TO `jdoe`@`%`", "my.app", "re`port", true},
+		{"GRANT SELECT (`id`, `name`) ON `app`.`report` TO `jdoe`@`%`", "app", "report", true},
+		{"GRANT SELECT ON *.`report` TO `jdoe`@`%`", "", "", false},
+		{"GRANT SELECT ON `app TO `jdoe`@`%`", "", "", false},
+		{"GRANT `admin`@`%` TO `jdoe`@`%`", "", "", false},
+	}
+
+	for _, c := range cases {
+		_, _, database, table, ok := parseGrant(c.grant)
+		if ok != c.ok || database != c.database || table != c.table {
+			t.Errorf("%s: got %q.%q (%t), want %q.%q (%t)", c.grant, database, table, ok, c.database, c.table, c.ok)
+		}
+	}
+}
+
+func TestUserOrRole(t *testing.T) {
+	cases := []struct {
+		user, host, role string
+		want             string
+	}{
+		{"jdoe", "localhost", "", "'jdoe'@'localhost'"},
+		{"jdoe", "", "", "'jdoe'@'%'"},
+		{"jdoe", " Example.COM ", "", "'jdoe'@'example.com'"},
+		{"o'brien", "%", "", `'o\'brien'@'%'`},
+		{"", "localhost", "developer", "'developer'"},
+	}
+
+	for _, c := range cases {
+		got, _, err := userOrRole(c.user, c.host, c.role, true)
+		if err != nil {
+			t.Fatalf("%s@%s: unexpected error: %s", c.user, c.host, err)
+		}
+		if got != c.want {
+			t.Errorf("%s@%s: got %s, want %s", c.user, c.host, got, c.want)
+		}
+	}
+}
+
 func TestAccGrant_role(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
 	dbName := fmt.Sprintf("tf-test-%d", rand.Intn(100))
@@ -53,13 +321,13 @@ func TestAccGrant_role(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck: func() {
 			testAccPreCheck(t)
-			db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 			if err != nil {
 				return
 			}
 
 			requiredVersion, _ := version.NewVersion("8.0.0")
-			currentVersion, err := serverVersion(db)
+			currentVersion, err := serverVersion(context.Background(), db)
 			if err != nil {
 				return
 			}
@@ -88,13 +356,13 @@ func TestAccGrant_roleToUser(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck: func() {
 			testAccPreCheck(t)
-			db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 			if err != nil {
 				return
 			}
 
 			requiredVersion, _ := version.NewVersion("8.0.0")
-			currentVersion, err := serverVersion(db)
+			currentVersion, err := serverVersion(context.Background(), db)
 			if err != nil {
 				return
 			}
@@ -129,7 +397,7 @@ func testAccPrivilegeExists(rn string, privilege string) resource.TestCheckFunc
 			return fmt.Errorf("grant id not set")
 		}
 
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -175,7 +443,7 @@ func testAccPrivilegeExists(rn string, privilege string) resource.TestCheckFunc
 }
 
 func testAccGrantCheckDestroy(s *terraform.State) error {
-	db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+	db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 	if err != nil {
 		return err
 	}
@@ -298,3 +566,78 @@ resource "mysql_grant" "test" {
 }
 `, dbName, dbName, roleName)
 }
+
+func testAccGrantConfig_twoGrants(dbName string, withSelect bool) string {
+	config := fmt.Sprintf(`
+resource "mysql_database" "test" {
+  name = "%s"
+}
+
+resource "mysql_user" "test" {
+  user     = "jdoe-%s"
+  host     = "example.com"
+}
+
+resource "mysql_grant" "insert" {
+  user       = "${mysql_user.test.user}"
+  host       = "${mysql_user.test.host}"
+  database   = "${mysql_database.test.name}"
+  privileges = ["INSERT", "GRANT OPTION"]
+}
+`, dbName, dbName)
+
+	if withSelect {
+		config += `
+resource "mysql_grant" "select" {
+  user       = "${mysql_user.test.user}"
+  host       = "${mysql_user.test.host}"
+  database   = "${mysql_database.test.name}"
+  privileges = ["SELECT"]
+}
+`
+	}
+	return config
+}
+
+func testAccGrantConfig_excludedPrivileges(dbName string) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+  name = "%s"
+}
+
+resource "mysql_user" "test" {
+  user     = "jdoe-%s"
+  host     = "example.com"
+}
+
+resource "mysql_grant" "test" {
+  user                = "${mysql_user.test.user}"
+  host                = "${mysql_user.test.host}"
+  database            = "${mysql_database.test.name}"
+  privileges          = ["ALL"]
+  excluded_privileges = ["DROP", "GRANT OPTION"]
+}
+`, dbName, dbName)
+}
+
+func testAccGrantConfig_proxy(dbName string) string {
+	return fmt.Sprintf(`
+resource "mysql_user" "test" {
+  user     = "jdoe-%s"
+  host     = "example.com"
+}
+
+resource "mysql_user" "app" {
+  user     = "app-%s"
+  host     = "example.com"
+}
+
+resource "mysql_grant" "test" {
+  user       = "${mysql_user.test.user}"
+  host       = "${mysql_user.test.host}"
+  proxy_user = "${mysql_user.app.user}"
+  proxy_host = "${mysql_user.app.host}"
+  privileges = ["PROXY"]
+}
+`, dbName, dbName)
+}
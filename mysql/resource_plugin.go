@@ -0,0 +1,194 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// pluginDoesNotExistErrCode is returned by UNINSTALL PLUGIN for plugins that
+// aren't installed.
+const pluginDoesNotExistErrCode = 1305
+
+func resourcePlugin() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: CreatePlugin,
+		ReadContext:   ReadPlugin,
+		DeleteContext: DeletePlugin,
+		Importer: &schema.ResourceImporter{
+			StateContext: ImportPlugin,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"soname": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"status": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func CreatePlugin(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	name, soname := d.Get("name").(string), d.Get("soname").(string)
+
+	library, installed, err := readPluginLibrary(ctx, db, name)
+	if err != nil {
+		return diag.Errorf("error reading plugin %s: %s", name, err)
+	}
+
+	if installed {
+		// The plugin may have been installed by hand or by the server's
+		// configuration; take it over rather than failing on INSTALL PLUGIN.
+		if !library.Valid {
+			return diag.Errorf("plugin %s is built into the server and can't be managed", name)
+		}
+		if !samePluginLibrary(library.String, soname) {
+			return diag.Errorf("plugin %s is already installed from %s, not %s", name, library.String, soname)
+		}
+		log.Printf("[INFO] Plugin %s is already installed; adopting it", name)
+	} else {
+		stmtSQL := fmt.Sprintf("INSTALL PLUGIN %s SONAME %s", quoteIdentifier(name), quoteString(soname))
+		log.Println("Executing statement:", stmtSQL)
+
+		_, err = execWithRetry(ctx, meta, db, stmtSQL)
+		if err != nil {
+			return diag.Errorf("error installing plugin %s: %s", name, err)
+		}
+	}
+
+	d.SetId(name)
+
+	return ReadPlugin(ctx, d, meta)
+}
+
+func ReadPlugin(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var library sql.NullString
+	var status string
+	err = db.QueryRowContext(ctx,
+		"SELECT PLUGIN_LIBRARY, PLUGIN_STATUS FROM information_schema.PLUGINS WHERE PLUGIN_NAME = ?",
+		d.Id()).Scan(&library, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[WARN] Plugin (%s) not found; removing from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.Errorf("error reading plugin %s: %s", d.Id(), err)
+	}
+
+	d.Set("name", d.Id())
+	// Keep soname as configured when it names the same library, e.g. without
+	// the .so suffix MariaDB allows.
+	if !samePluginLibrary(library.String, d.Get("soname").(string)) {
+		d.Set("soname", library.String)
+	}
+	d.Set("status", status)
+
+	return nil
+}
+
+func DeletePlugin(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	stmtSQL := "UNINSTALL PLUGIN " + quoteIdentifier(d.Id())
+	log.Println("Executing statement:", stmtSQL)
+
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == pluginDoesNotExistErrCode {
+			return nil
+		}
+		return diag.Errorf("error uninstalling plugin %s: %s", d.Id(), err)
+	}
+
+	return nil
+}
+
+func ImportPlugin(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return nil, err
+	}
+
+	library, installed, err := readPluginLibrary(ctx, db, d.Id())
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, fmt.Errorf("plugin %s is not installed", d.Id())
+	}
+	if !library.Valid {
+		return nil, fmt.Errorf("plugin %s is built into the server and can't be managed", d.Id())
+	}
+
+	d.Set("name", d.Id())
+	d.Set("soname", library.String)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// readPluginLibrary returns the shared library a plugin was installed from,
+// which is NULL for plugins built into the server, and whether the plugin is
+// installed at all.
+func readPluginLibrary(ctx context.Context, db *sql.DB, name string) (sql.NullString, bool, error) {
+	var library sql.NullString
+	err := db.QueryRowContext(ctx,
+		"SELECT PLUGIN_LIBRARY FROM information_schema.PLUGINS WHERE PLUGIN_NAME = ?",
+		name).Scan(&library)
+	if errors.Is(err, sql.ErrNoRows) {
+		return library, false, nil
+	}
+	if err != nil {
+		return library, false, err
+	}
+	return library, true, nil
+}
+
+// samePluginLibrary reports whether two SONAMEs name the same library.
+// MariaDB accepts a SONAME without the platform's extension, while
+// information_schema.PLUGINS always lists the file name.
+func samePluginLibrary(a, b string) bool {
+	return strings.TrimSuffix(a, path.Ext(a)) == strings.TrimSuffix(b, path.Ext(b))
+}
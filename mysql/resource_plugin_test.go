@@ -0,0 +1,104 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// A password validation plugin ships with both MySQL and MariaDB, under
+// different names.
+func TestAccPlugin(t *testing.T) {
+	testAccPlugin(t, "validate_password", "validate_password.so", false)
+}
+
+func TestAccPlugin_mariaDB(t *testing.T) {
+	testAccPlugin(t, "simple_password_check", "simple_password_check.so", true)
+}
+
+func testAccPlugin(t *testing.T, name, soname string, mariaDB bool) {
+	resourceName := "mysql_plugin.test"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				return
+			}
+			versionString, err := serverVersionString(context.Background(), db)
+			if err != nil {
+				return
+			}
+			if _, ok := mariaDBVersion(versionString); ok != mariaDB {
+				t.Skipf("plugin %s is not available on %s", name, versionString)
+			}
+			if _, installed, _ := readPluginLibrary(context.Background(), db, name); installed {
+				t.Skipf("plugin %s is already installed", name)
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: func(s *terraform.State) error { return testAccPluginCheckDestroy(name) },
+		Steps: []resource.TestStep{
+			{
+				Config: testAccPluginConfig(name, soname),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "name", name),
+					resource.TestCheckResourceAttr(resourceName, "soname", soname),
+					resource.TestCheckResourceAttr(resourceName, "status", "ACTIVE"),
+				),
+			},
+			{
+				ResourceName:      resourceName,
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func testAccPluginCheckDestroy(name string) error {
+	db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+	if err != nil {
+		return err
+	}
+
+	_, installed, err := readPluginLibrary(context.Background(), db, name)
+	if err != nil {
+		return err
+	}
+	if installed {
+		return fmt.Errorf("plugin %s still installed after destroy", name)
+	}
+	return nil
+}
+
+func testAccPluginConfig(name, soname string) string {
+	return fmt.Sprintf(`
+resource "mysql_plugin" "test" {
+  name   = "%s"
+  soname = "%s"
+}
+`, name, soname)
+}
+
+func TestSamePluginLibrary(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"validate_password.so", "validate_password.so", true},
+		{"simple_password_check.so", "simple_password_check", true},
+		{"auth_pam.dll", "auth_pam", true},
+		{"auth_pam.so", "auth_socket.so", false},
+		{"", "validate_password.so", false},
+	}
+	for _, c := range cases {
+		if got := samePluginLibrary(c.a, c.b); got != c.want {
+			t.Errorf("samePluginLibrary(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
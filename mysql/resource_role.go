@@ -1,17 +1,20 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
 	"log"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func resourceRole() *schema.Resource {
 	return &schema.Resource{
-		Create: CreateRole,
-		Read:   ReadRole,
-		Delete: DeleteRole,
+		CreateContext: CreateRole,
+		ReadContext:   ReadRole,
+		DeleteContext: DeleteRole,
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -23,10 +26,14 @@ func resourceRole() *schema.Resource {
 	}
 }
 
-func CreateRole(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func CreateRole(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	roleName := d.Get("name").(string)
@@ -34,9 +41,9 @@ func CreateRole(d *schema.ResourceData, meta interface{}) error {
 	sql := fmt.Sprintf("CREATE ROLE '%s'", roleName)
 	log.Printf("[DEBUG] SQL: %s", sql)
 
-	_, err = db.Exec(sql)
+	_, err = execWithRetry(ctx, meta, db, sql)
 	if err != nil {
-		return fmt.Errorf("error creating role: %s", err)
+		return diag.Errorf("error creating role: %s", err)
 	}
 
 	d.SetId(roleName)
@@ -44,39 +51,47 @@ func CreateRole(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func ReadRole(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func ReadRole(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	sql := fmt.Sprintf("SHOW GRANTS FOR '%s'", d.Id())
 	log.Printf("[DEBUG] SQL: %s", sql)
 
-	_, err = db.Exec(sql)
+	rows, err := db.QueryContext(ctx, sql)
 	if err != nil {
-		log.Printf("[WARN] Role (%s) not found; removing from state", d.Id())
-		d.SetId("")
-		return nil
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == nonexistingGrantErrCode {
+			log.Printf("[WARN] Role (%s) not found; removing from state", d.Id())
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error reading role %s: %s", d.Id(), err)
 	}
+	rows.Close()
 
 	d.Set("name", d.Id())
 
 	return nil
 }
 
-func DeleteRole(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func DeleteRole(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	sql := fmt.Sprintf("DROP ROLE '%s'", d.Get("name").(string))
 	log.Printf("[DEBUG] SQL: %s", sql)
 
-	_, err = db.Exec(sql)
+	_, err = execWithRetry(ctx, meta, db, sql)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	return nil
@@ -1,13 +1,14 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"testing"
 
 	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func TestAccRole_basic(t *testing.T) {
@@ -17,13 +18,13 @@ func TestAccRole_basic(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck: func() {
 			testAccPreCheck(t)
-			db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 			if err != nil {
 				return
 			}
 
 			requiredVersion, _ := version.NewVersion("8.0.0")
-			currentVersion, err := serverVersion(db)
+			currentVersion, err := serverVersion(context.Background(), db)
 			if err != nil {
 				return
 			}
@@ -46,9 +47,57 @@ func TestAccRole_basic(t *testing.T) {
 	})
 }
 
+func TestAccRole_disappears(t *testing.T) {
+	roleName := "tf-test-role-disappears"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				return
+			}
+
+			requiredVersion, _ := version.NewVersion("8.0.0")
+			currentVersion, err := serverVersion(context.Background(), db)
+			if err != nil {
+				return
+			}
+
+			if currentVersion.LessThan(requiredVersion) {
+				t.Skip("Roles require MySQL 8+")
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccRoleCheckDestroy(roleName),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccRoleConfig_basic(roleName),
+				Check: resource.ComposeTestCheckFunc(
+					testAccRoleExists(roleName),
+					testAccRoleDrop(roleName),
+				),
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+func testAccRoleDrop(roleName string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(fmt.Sprintf("DROP ROLE '%s'", roleName))
+		return err
+	}
+}
+
 func testAccRoleExists(roleName string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -85,7 +134,7 @@ func testAccGetRoleGrantCount(roleName string, db *sql.DB) (int, error) {
 
 func testAccRoleCheckDestroy(roleName string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,267 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// sequenceMinMariaDBVersion is the first MariaDB release with CREATE
+// SEQUENCE. MySQL doesn't have sequences.
+const sequenceMinMariaDBVersion = "10.3.0"
+
+// sequenceOptions maps the attributes of mysql_sequence to their clause in
+// CREATE and ALTER SEQUENCE, in the order they are written.
+var sequenceOptions = []struct {
+	attr   string
+	clause string
+}{
+	{"start", "START WITH"},
+	{"increment", "INCREMENT BY"},
+	{"minvalue", "MINVALUE"},
+	{"maxvalue", "MAXVALUE"},
+	{"cache", "CACHE"},
+}
+
+func resourceSequence() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: CreateSequence,
+		UpdateContext: UpdateSequence,
+		ReadContext:   ReadSequence,
+		DeleteContext: DeleteSequence,
+		Importer: &schema.ResourceImporter{
+			StateContext: ImportSequence,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"database": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			// start, minvalue and maxvalue default to values that depend on
+			// the direction of increment, so the server picks them.
+			"start": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Computed: true,
+			},
+
+			"increment": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  1,
+			},
+
+			"minvalue": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Computed: true,
+			},
+
+			"maxvalue": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Computed: true,
+			},
+
+			"cache": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  1000,
+			},
+		},
+	}
+}
+
+func CreateSequence(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := checkSequenceSupport(ctx, db); err != nil {
+		return diag.FromErr(err)
+	}
+
+	var clauses []string
+	for _, o := range sequenceOptions {
+		// Unset options are left to the server, whose defaults are the ones
+		// of the schema.
+		if !d.GetRawConfig().GetAttr(o.attr).IsNull() {
+			clauses = append(clauses, fmt.Sprintf("%s %d", o.clause, d.Get(o.attr).(int)))
+		}
+	}
+
+	stmtSQL := strings.Join(append([]string{"CREATE SEQUENCE", sequenceIdentifier(d)}, clauses...), " ")
+	log.Println("Executing statement:", stmtSQL)
+
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
+	if err != nil {
+		return diag.Errorf("error creating sequence: %s", err)
+	}
+
+	d.SetId(fmt.Sprintf("%s.%s", d.Get("database").(string), d.Get("name").(string)))
+
+	return ReadSequence(ctx, d, meta)
+}
+
+func UpdateSequence(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	var clauses []string
+	for _, o := range sequenceOptions {
+		if d.HasChange(o.attr) {
+			clauses = append(clauses, fmt.Sprintf("%s %d", o.clause, d.Get(o.attr).(int)))
+		}
+	}
+	if len(clauses) == 0 {
+		return ReadSequence(ctx, d, meta)
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	stmtSQL := fmt.Sprintf("ALTER SEQUENCE %s %s", sequenceIdentifier(d), strings.Join(clauses, " "))
+	log.Println("Executing statement:", stmtSQL)
+
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
+	if err != nil {
+		return diag.Errorf("error altering sequence: %s", err)
+	}
+
+	return ReadSequence(ctx, d, meta)
+}
+
+func ReadSequence(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	database, name := d.Get("database").(string), d.Get("name").(string)
+
+	var count int
+	err = db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND TABLE_TYPE = 'SEQUENCE'",
+		database, name).Scan(&count)
+	if err != nil {
+		return diag.Errorf("error reading sequence %s: %s", d.Id(), err)
+	}
+	if count == 0 {
+		log.Printf("[WARN] Sequence (%s) not found; removing from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	// A sequence is a table with a single row holding its parameters.
+	stmtSQL := "SELECT start_value, increment, minimum_value, maximum_value, cache_size FROM " + sequenceIdentifier(d)
+	log.Println("Executing query:", stmtSQL)
+
+	var start, increment, minvalue, maxvalue, cache int64
+	err = db.QueryRowContext(ctx, stmtSQL).Scan(&start, &increment, &minvalue, &maxvalue, &cache)
+	if err != nil {
+		return diag.Errorf("error reading sequence %s: %s", d.Id(), err)
+	}
+
+	d.Set("start", int(start))
+	d.Set("increment", int(increment))
+	d.Set("minvalue", int(minvalue))
+	d.Set("maxvalue", int(maxvalue))
+	d.Set("cache", int(cache))
+
+	return nil
+}
+
+func DeleteSequence(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	stmtSQL := "DROP SEQUENCE " + sequenceIdentifier(d)
+	log.Println("Executing statement:", stmtSQL)
+
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	return nil
+}
+
+func ImportSequence(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	// terraform import mysql_sequence.seq database.name
+	database, name, ok := strings.Cut(d.Id(), ".")
+	if !ok || database == "" || name == "" {
+		return nil, fmt.Errorf("wrong ID format %s (expected DATABASE.NAME)", d.Id())
+	}
+
+	d.Set("database", database)
+	d.Set("name", name)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func sequenceIdentifier(d *schema.ResourceData) string {
+	return quoteIdentifier(d.Get("database").(string)) + "." + quoteIdentifier(d.Get("name").(string))
+}
+
+// checkSequenceSupport fails on servers without sequences, with a clearer
+// message than the syntax error CREATE SEQUENCE would give.
+func checkSequenceSupport(ctx context.Context, db *sql.DB) error {
+	versionString, err := serverVersionString(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	currentVersion, ok := mariaDBVersion(versionString)
+	requiredVersion, _ := version.NewVersion(sequenceMinMariaDBVersion)
+	if !ok || currentVersion.LessThan(requiredVersion) {
+		return fmt.Errorf("mysql_sequence requires MariaDB %s or newer, the server is %s", sequenceMinMariaDBVersion, versionString)
+	}
+	return nil
+}
+
+// mariaDBVersion parses the version of a MariaDB server from @@version, e.g.
+// 10.6.12-MariaDB-1:10.6.12+maria~ubu2004. It reports false for other
+// servers.
+func mariaDBVersion(versionString string) (*version.Version, bool) {
+	if !strings.Contains(versionString, "MariaDB") {
+		return nil, false
+	}
+
+	// Some releases carry a 5.5.5- prefix for the benefit of old clients.
+	versionString = strings.TrimPrefix(versionString, "5.5.5-")
+	v, _, _ := strings.Cut(versionString, "-")
+	parsed, err := version.NewVersion(v)
+	if err != nil {
+		return nil, false
+	}
+	return parsed, true
+}
@@ -0,0 +1,113 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccSequence(t *testing.T) {
+	resourceName := "mysql_sequence.test"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				return
+			}
+			if err := checkSequenceSupport(context.Background(), db); err != nil {
+				t.Skip(err)
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccSequenceCheckDestroy("terraform_acceptance_test_sequence", "ids"),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccSequenceConfig(10, 1000),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "start", "100"),
+					resource.TestCheckResourceAttr(resourceName, "increment", "10"),
+					resource.TestCheckResourceAttr(resourceName, "minvalue", "1"),
+					resource.TestCheckResourceAttr(resourceName, "maxvalue", "9223372036854775806"),
+					resource.TestCheckResourceAttr(resourceName, "cache", "1000"),
+				),
+			},
+			{
+				Config: testAccSequenceConfig(5, 0),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "increment", "5"),
+					resource.TestCheckResourceAttr(resourceName, "cache", "0"),
+				),
+			},
+			{
+				ResourceName:      resourceName,
+				ImportState:       true,
+				ImportStateId:     "terraform_acceptance_test_sequence.ids",
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func TestMariaDBVersion(t *testing.T) {
+	cases := []struct {
+		version string
+		want    string
+	}{
+		{"10.6.12-MariaDB-1:10.6.12+maria~ubu2004", "10.6.12"},
+		{"5.5.5-10.3.39-MariaDB", "10.3.39"},
+		{"11.4.2-MariaDB-log", "11.4.2"},
+		{"8.0.32", ""},
+		{"8.0.32-0ubuntu0.22.04.2", ""},
+	}
+	for _, c := range cases {
+		v, ok := mariaDBVersion(c.version)
+		if c.want == "" {
+			if ok {
+				t.Errorf("%s: expected no MariaDB version, got %s", c.version, v)
+			}
+			continue
+		}
+		if !ok || v.String() != c.want {
+			t.Errorf("%s: got %v, want %s", c.version, v, c.want)
+		}
+	}
+}
+
+func testAccSequenceCheckDestroy(database string, name string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+		if err != nil {
+			return err
+		}
+
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", database, name).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return fmt.Errorf("sequence %s.%s still exists after destroy", database, name)
+		}
+		return nil
+	}
+}
+
+func testAccSequenceConfig(increment int, cache int) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+    name = "terraform_acceptance_test_sequence"
+}
+
+resource "mysql_sequence" "test" {
+    database = mysql_database.test.name
+    name = "ids"
+    start = 100
+    increment = %d
+    cache = %d
+}`, increment, cache)
+}
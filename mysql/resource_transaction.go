@@ -0,0 +1,176 @@
+package mysql
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceTransaction() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: CreateTransaction,
+		ReadContext:   ReadTransaction,
+		UpdateContext: UpdateTransaction,
+		DeleteContext: DeleteTransaction,
+
+		Schema: map[string]*schema.Schema{
+			"statements": {
+				Type:     schema.TypeList,
+				Required: true,
+				ForceNew: true,
+				MinItems: 1,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"delimiter": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"triggers": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"verify_query": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+	}
+}
+
+func CreateTransaction(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var statements []string
+	for _, stmt := range d.Get("statements").([]interface{}) {
+		statements = append(statements, splitStatements(stmt.(string), d.Get("delimiter").(string))...)
+	}
+
+	for i, stmtSQL := range statements {
+		log.Println("Executing statement:", stmtSQL)
+
+		if _, err := tx.ExecContext(ctx, stmtSQL); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("[WARN] rollback failed: %s", rbErr)
+			}
+			return diag.Errorf("error executing statement %d (%s), transaction rolled back: %s", i, stmtSQL, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return diag.Errorf("error committing transaction: %s", err)
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(id.String())
+
+	return ReadTransaction(ctx, d, meta)
+}
+
+func ReadTransaction(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	verifyQuery := d.Get("verify_query").(string)
+	if verifyQuery == "" {
+		return nil
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	log.Println("Executing query:", verifyQuery)
+	rows, err := db.QueryContext(ctx, verifyQuery)
+	if err != nil {
+		return diag.Errorf("error running verify_query (%s): %s", verifyQuery, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() && rows.Err() == nil {
+		log.Printf("[WARN] verify_query returned no rows for transaction %s; removing from state", d.Id())
+		d.SetId("")
+	}
+	return diag.FromErr(rows.Err())
+}
+
+func UpdateTransaction(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	// Only verify_query can change in place.
+	return ReadTransaction(ctx, d, meta)
+}
+
+func DeleteTransaction(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	// Statements that were committed can't be undone generically; we only
+	// need TF to remove the resource from the state file.
+	return nil
+}
+
+// splitStatements splits sql on delimiter, like the mysql client's DELIMITER
+// command, so that one entry can hold several statements. Delimiters inside
+// quoted strings and identifiers are left alone. Without a delimiter sql is a
+// single statement.
+func splitStatements(sql string, delimiter string) []string {
+	if delimiter == "" {
+		return []string{sql}
+	}
+
+	var statements []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case strings.HasPrefix(sql[i:], delimiter):
+			statements = append(statements, sql[start:i])
+			i += len(delimiter) - 1
+			start = i + 1
+		}
+	}
+	statements = append(statements, sql[start:])
+
+	nonEmpty := statements[:0]
+	for _, stmt := range statements {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			nonEmpty = append(nonEmpty, stmt)
+		}
+	}
+	return nonEmpty
+}
@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestAccTransaction_basic(t *testing.T) {
+	dbName := "terraform_acceptance_test_tx"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccDatabaseCheckDestroy(dbName),
+		Steps: []resource.TestStep{
+			{
+				Config: testAccTransactionConfig_basic(dbName),
+				Check: resource.ComposeTestCheckFunc(
+					testAccTransactionRowCount(dbName, 2),
+					resource.TestCheckResourceAttr("mysql_transaction.test", "statements.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccTransaction_rollback(t *testing.T) {
+	dbName := "terraform_acceptance_test_tx"
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccDatabaseCheckDestroy(dbName),
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccTransactionConfig_failing(dbName),
+				ExpectError: regexp.MustCompile("transaction rolled back"),
+			},
+			{
+				Config: testAccTransactionConfig_table(dbName),
+				Check:  testAccTransactionRowCount(dbName, 0),
+			},
+		},
+	})
+}
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		sql       string
+		delimiter string
+		want      []string
+	}{
+		{"INSERT INTO t VALUES (1); INSERT INTO t VALUES (2)", "", []string{"INSERT INTO t VALUES (1); INSERT INTO t VALUES (2)"}},
+		{"INSERT INTO t VALUES (1); INSERT INTO t VALUES (2);", ";", []string{"INSERT INTO t VALUES (1)", "INSERT INTO t VALUES (2)"}},
+		{"INSERT INTO t VALUES ('a;b', \"c;d\", 'it''s;', 'x\\';y');", ";", []string{"INSERT INTO t VALUES ('a;b', \"c;d\", 'it''s;', 'x\\';y')"}},
+		{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END $$ CALL p() $$", "$$", []string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "CALL p()"}},
+		{"SELECT 1 FROM `a;b`", ";", []string{"SELECT 1 FROM `a;b`"}},
+	}
+
+	for _, c := range cases {
+		got := splitStatements(c.sql, c.delimiter)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want) {
+			t.Errorf("%s: got %q, want %q", c.sql, got, c.want)
+		}
+	}
+}
+
+func testAccTransactionRowCount(dbName string, want int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+		if err != nil {
+			return err
+		}
+
+		var count int
+		err = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM `%s`.`items`", dbName)).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count != want {
+			return fmt.Errorf("expected %d rows, got %d", want, count)
+		}
+		return nil
+	}
+}
+
+func testAccTransactionConfig_table(dbName string) string {
+	return fmt.Sprintf(`
+resource "mysql_database" "test" {
+  name = "%s"
+}
+
+resource "mysql_transaction" "schema" {
+  statements = ["CREATE TABLE ${mysql_database.test.name}.items (id INT PRIMARY KEY)"]
+}
+`, dbName)
+}
+
+func testAccTransactionConfig_basic(dbName string) string {
+	return testAccTransactionConfig_table(dbName) + `
+resource "mysql_transaction" "test" {
+  statements = [
+    "INSERT INTO ${mysql_database.test.name}.items VALUES (1)",
+    "INSERT INTO ${mysql_database.test.name}.items VALUES (2)",
+  ]
+  verify_query = "SELECT 1 FROM ${mysql_database.test.name}.items WHERE id = 2"
+
+  depends_on = [mysql_transaction.schema]
+}
+`
+}
+
+func testAccTransactionConfig_failing(dbName string) string {
+	return testAccTransactionConfig_table(dbName) + `
+resource "mysql_transaction" "test" {
+  statements = [
+    "INSERT INTO ${mysql_database.test.name}.items VALUES (1)",
+    "INSERT INTO ${mysql_database.test.name}.items VALUES (1)",
+  ]
+
+  depends_on = [mysql_transaction.schema]
+}
+`
+}
@@ -1,38 +1,45 @@
 package mysql
 
 import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
 
-	"errors"
-
 	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
+// nativePasswordPlugin is the plugin password_hash is for without auth_plugin.
+const nativePasswordPlugin = "mysql_native_password"
+
 func resourceUser() *schema.Resource {
 	return &schema.Resource{
-		Create: CreateUser,
-		Update: UpdateUser,
-		Read:   ReadUser,
-		Delete: DeleteUser,
+		CreateContext: CreateUser,
+		UpdateContext: UpdateUser,
+		ReadContext:   ReadUser,
+		DeleteContext: DeleteUser,
 		Importer: &schema.ResourceImporter{
-			State: ImportUser,
+			StateContext: ImportUser,
 		},
 
 		Schema: map[string]*schema.Schema{
 			"user": {
 				Type:     schema.TypeString,
 				Required: true,
-				ForceNew: true,
 			},
 
 			"host": {
-				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
-				Default:  "localhost",
+				Type:      schema.TypeString,
+				Optional:  true,
+				Default:   "localhost",
+				StateFunc: func(v interface{}) string { return normalizeHost(v.(string)) },
 			},
 
 			"plaintext_password": {
@@ -50,27 +57,127 @@ func resourceUser() *schema.Resource {
 				Deprecated:    "Please use plaintext_password instead",
 			},
 
+			"plaintext_password_wo": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				WriteOnly:     true,
+				ConflictsWith: []string{"plaintext_password", "password"},
+			},
+
+			"plaintext_password_wo_version": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				RequiredWith: []string{"plaintext_password_wo"},
+			},
+
+			"password_hash": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				ConflictsWith: []string{"plaintext_password", "password", "plaintext_password_wo"},
+			},
+
 			"auth_plugin": {
 				Type:          schema.TypeString,
 				Optional:      true,
 				ForceNew:      true,
-				ConflictsWith: []string{"plaintext_password", "password"},
+				ConflictsWith: []string{"plaintext_password", "password", "plaintext_password_wo"},
 			},
 
 			"tls_option": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "NONE",
+				ValidateFunc:     validateTLSOption,
+				DiffSuppressFunc: suppressTLSOptionDiff,
+			},
+
+			// tls_option broken down into its parts, as read back from the
+			// server.
+			"tls_requirement": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"cipher": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"issuer": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"subject": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			"comment": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
-				Default:  "NONE",
+			},
+
+			"attribute": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateFunc:     validation.StringIsJSON,
+				DiffSuppressFunc: structure.SuppressJsonDiff,
+			},
+
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			// Granted right after the user is created and kept in state as
+			// configured; later changes are not applied.
+			"initial_privileges": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"database": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"table": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "*",
+						},
+						"privileges": {
+							Type:     schema.TypeSet,
+							Required: true,
+							Elem: &schema.Schema{
+								Type:         schema.TypeString,
+								ValidateFunc: validatePrivilege,
+							},
+							Set: schema.HashString,
+						},
+					},
+				},
 			},
 		},
 	}
 }
 
-func CreateUser(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func CreateUser(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	var authStm string
@@ -88,53 +195,144 @@ func CreateUser(d *schema.ResourceData, meta interface{}) error {
 		}
 	}
 
-	stmtSQL := fmt.Sprintf("CREATE USER '%s'@'%s'",
+	// Normalized the way the host is stored in state and looked up by
+	// ReadUser, as d.Get returns the configured value during Create.
+	account := fmt.Sprintf("'%s'@'%s'",
 		d.Get("user").(string),
-		d.Get("host").(string))
+		normalizeHost(d.Get("host").(string)))
+	adopt := d.Get("adopt_existing").(bool)
 
 	var password string
 	if v, ok := d.GetOk("plaintext_password"); ok {
 		password = v.(string)
+	} else if v := writeOnlyString(d, "plaintext_password_wo"); v != "" {
+		password = v
 	} else {
 		password = d.Get("password").(string)
 	}
 
-	if auth == "AWSAuthenticationPlugin" && d.Get("host").(string) == "localhost" {
-		return errors.New("cannot use IAM auth against localhost")
-	}
-
-	if authStm != "" {
-		stmtSQL = stmtSQL + authStm
-	} else {
-		stmtSQL = stmtSQL + fmt.Sprintf(" IDENTIFIED BY '%s'", password)
+	if auth == "AWSAuthenticationPlugin" && normalizeHost(d.Get("host").(string)) == "localhost" {
+		return diag.Errorf("cannot use IAM auth against localhost")
 	}
 
 	requiredVersion, _ := version.NewVersion("5.7.0")
-	currentVersion, err := serverVersion(db)
+	currentVersion, err := serverVersion(ctx, db)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+
+	var clauses string
+	if hash := d.Get("password_hash").(string); hash != "" {
+		clause, err := passwordHashClause(auth, hash, currentVersion)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		clauses += clause
+	} else if authStm != "" {
+		clauses += authStm
+	} else if password != "" || !adopt {
+		// An adopted user without a password in the configuration keeps
+		// the one it has.
+		clauses += fmt.Sprintf(" IDENTIFIED BY '%s'", password)
 	}
 
 	if currentVersion.GreaterThan(requiredVersion) && d.Get("tls_option").(string) != "" {
-		stmtSQL += fmt.Sprintf(" REQUIRE %s", d.Get("tls_option").(string))
+		clauses += fmt.Sprintf(" REQUIRE %s", d.Get("tls_option").(string))
+	}
+
+	// Checked up front, so that an unsupported privilege doesn't leave a
+	// user behind.
+	grants, err := initialGrantStatements(ctx, d, db)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var existed bool
+	stmtSQL := "CREATE USER " + account + clauses
+	if adopt {
+		existed, err = userExists(ctx, db, currentVersion, d.Get("user").(string), normalizeHost(d.Get("host").(string)))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		stmtSQL = "CREATE USER IF NOT EXISTS " + account + clauses
 	}
 
 	log.Println("Executing statement:", stmtSQL)
-	_, err = db.Exec(stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	user := fmt.Sprintf("%s@%s", d.Get("user").(string), d.Get("host").(string))
+	// IF NOT EXISTS left an existing user untouched, so its password and
+	// TLS requirement are brought in line with the configuration here.
+	if existed {
+		log.Printf("[INFO] User %s already exists; adopting it", account)
+		if clauses != "" {
+			stmtSQL = "ALTER USER " + account + clauses
+			log.Println("Executing statement:", stmtSQL)
+			if _, err := execWithRetry(ctx, meta, db, stmtSQL); err != nil {
+				return diag.FromErr(err)
+			}
+		}
+	}
+
+	if err := grantInitialPrivileges(ctx, d, meta, db, grants, !existed); err != nil {
+		return diag.FromErr(err)
+	}
+
+	user := fmt.Sprintf("%s@%s", d.Get("user").(string), normalizeHost(d.Get("host").(string)))
 	d.SetId(user)
 
+	if err := updateUserAttributes(ctx, d, meta, db); err != nil {
+		return diag.FromErr(err)
+	}
+
 	return nil
 }
 
-func UpdateUser(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func UpdateUser(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
+	}
+
+	if d.HasChange("initial_privileges") {
+		log.Printf("[WARN] initial_privileges of %s changed; they are only granted when the user is created, use mysql_grant to change privileges", d.Id())
+	}
+
+	if d.HasChange("user") || d.HasChange("host") {
+		oldUser, newUser := d.GetChange("user")
+		oldHost, newHost := d.GetChange("host")
+		// Looked up the way RENAME USER stores it, so that an account
+		// differing only in the case of the host is found.
+		newHost = normalizeHost(newHost.(string))
+
+		var count int
+		err = db.QueryRowContext(ctx, "SELECT COUNT(1) FROM mysql.user WHERE user = ? AND host = ?", newUser, newHost).Scan(&count)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		if count > 0 {
+			return diag.Errorf("cannot rename user %s@%s: user %s@%s already exists", oldUser, oldHost, newUser, newHost)
+		}
+
+		stmtSQL := fmt.Sprintf("RENAME USER '%s'@'%s' TO '%s'@'%s'",
+			oldUser.(string),
+			oldHost.(string),
+			newUser.(string),
+			newHost.(string))
+
+		log.Println("Executing statement:", stmtSQL)
+		_, err = execWithRetry(ctx, meta, db, stmtSQL)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
+		d.SetId(fmt.Sprintf("%s@%s", newUser.(string), newHost.(string)))
 	}
 
 	var auth string
@@ -142,9 +340,13 @@ func UpdateUser(d *schema.ResourceData, meta interface{}) error {
 		auth = v.(string)
 	}
 
-	if len(auth) > 0 {
-		// nothing to change, return
-		return nil
+	if d.HasChange("password_hash") && d.Get("password_hash").(string) != "" {
+		if err := updatePasswordHash(ctx, d, meta, db, auth); err != nil {
+			return diag.FromErr(err)
+		}
+	} else if len(auth) > 0 {
+		// no password to change
+		return diag.FromErr(updateUserAttributes(ctx, d, meta, db))
 	}
 
 	var newpw interface{}
@@ -152,6 +354,10 @@ func UpdateUser(d *schema.ResourceData, meta interface{}) error {
 		_, newpw = d.GetChange("plaintext_password")
 	} else if d.HasChange("password") {
 		_, newpw = d.GetChange("password")
+	} else if d.HasChange("plaintext_password_wo_version") {
+		// Write-only values never show up as a change; bumping the version
+		// is what tells us to apply the current one.
+		newpw = writeOnlyString(d, "plaintext_password_wo")
 	} else {
 		newpw = nil
 	}
@@ -160,98 +366,124 @@ func UpdateUser(d *schema.ResourceData, meta interface{}) error {
 		var stmtSQL string
 
 		/* ALTER USER syntax introduced in MySQL 5.7.6 deprecates SET PASSWORD (GH-8230) */
-		serverVersion, err := serverVersion(db)
+		serverVersion, err := serverVersion(ctx, db)
 		if err != nil {
-			return fmt.Errorf("Could not determine server version: %s", err)
+			return diag.Errorf("Could not determine server version: %s", err)
 		}
 
 		ver, _ := version.NewVersion("5.7.6")
 		if serverVersion.LessThan(ver) {
 			stmtSQL = fmt.Sprintf("SET PASSWORD FOR '%s'@'%s' = PASSWORD('%s')",
 				d.Get("user").(string),
-				d.Get("host").(string),
+				normalizeHost(d.Get("host").(string)),
 				newpw.(string))
 		} else {
 			stmtSQL = fmt.Sprintf("ALTER USER '%s'@'%s' IDENTIFIED BY '%s'",
 				d.Get("user").(string),
-				d.Get("host").(string),
+				normalizeHost(d.Get("host").(string)),
 				newpw.(string))
 		}
 
 		log.Println("Executing query:", stmtSQL)
-		_, err = db.Exec(stmtSQL)
+		_, err = execWithRetry(ctx, meta, db, stmtSQL)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 	}
 
 	requiredVersion, _ := version.NewVersion("5.7.0")
-	currentVersion, err := serverVersion(db)
+	currentVersion, err := serverVersion(ctx, db)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	if d.HasChange("tls_option") && currentVersion.GreaterThan(requiredVersion) {
 		var stmtSQL string
 
-		stmtSQL = fmt.Sprintf("ALTER USER '%s'@'%s'  REQUIRE %s",
+		// An empty tls_option resets the account, rather than leaving the
+		// old requirement in place.
+		option := d.Get("tls_option").(string)
+		if option == "" {
+			option = "NONE"
+		}
+		stmtSQL = fmt.Sprintf("ALTER USER '%s'@'%s' REQUIRE %s",
 			d.Get("user").(string),
-			d.Get("host").(string),
-			fmt.Sprintf(" REQUIRE %s", d.Get("tls_option").(string)))
+			normalizeHost(d.Get("host").(string)),
+			option)
 
 		log.Println("Executing query:", stmtSQL)
-		_, err := db.Exec(stmtSQL)
+		_, err := execWithRetry(ctx, meta, db, stmtSQL)
 		if err != nil {
-			return err
+			return diag.FromErr(err)
 		}
 	}
 
+	if err := updateUserAttributes(ctx, d, meta, db); err != nil {
+		return diag.FromErr(err)
+	}
+
 	return nil
 }
 
-func ReadUser(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func ReadUser(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
-	stmtSQL := fmt.Sprintf("SELECT USER FROM mysql.user WHERE USER='%s'",
-		d.Get("user").(string))
+	stmtSQL := "SELECT HOST FROM mysql.user WHERE USER = ? AND HOST = ?"
 
 	log.Println("Executing statement:", stmtSQL)
 
-	rows, err := db.Query(stmtSQL)
+	var host string
+	err = db.QueryRowContext(ctx, stmtSQL, d.Get("user").(string), normalizeHost(d.Get("host").(string))).Scan(&host)
+	if err == sql.ErrNoRows {
+		d.SetId("")
+		return nil
+	}
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	defer rows.Close()
+	d.Set("host", normalizeHost(host))
 
-	if !rows.Next() && rows.Err() == nil {
-		d.SetId("")
+	if err := readPasswordHash(ctx, d, db); err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := readTLSRequirement(ctx, d, db); err != nil {
+		return diag.FromErr(err)
 	}
-	return rows.Err()
+
+	if err := readUserAttributes(ctx, d, db); err != nil {
+		return diag.FromErr(err)
+	}
+	return nil
 }
 
-func DeleteUser(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func DeleteUser(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	stmtSQL := fmt.Sprintf("DROP USER '%s'@'%s'",
 		d.Get("user").(string),
-		d.Get("host").(string))
+		normalizeHost(d.Get("host").(string)))
 
 	log.Println("Executing statement:", stmtSQL)
 
-	_, err = db.Exec(stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
 	if err == nil {
 		d.SetId("")
 	}
-	return err
+	return diag.FromErr(err)
 }
 
-func ImportUser(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+func ImportUser(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
 	// terraform import mysql_user.user user@host
 	userHost := strings.SplitN(d.Id(), "@", 2)
 
@@ -260,16 +492,16 @@ func ImportUser(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceDat
 	}
 
 	user := userHost[0]
-	host := userHost[1]
+	host := normalizeHost(userHost[1])
 
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 
 	if err != nil {
 		return nil, err
 	}
 
 	var count int
-	err = db.QueryRow("SELECT COUNT(1) FROM mysql.user WHERE user = ? AND host = ?", user, host).Scan(&count)
+	err = db.QueryRowContext(ctx, "SELECT COUNT(1) FROM mysql.user WHERE user = ? AND host = ?", user, host).Scan(&count)
 
 	if err != nil {
 		return nil, err
@@ -281,8 +513,542 @@ func ImportUser(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceDat
 
 	d.Set("user", user)
 	d.Set("host", host)
-	// importing tls_option is complicated
+	// Read fills in the actual requirement where SHOW CREATE USER is
+	// available.
 	d.Set("tls_option", "NONE")
 
 	return []*schema.ResourceData{d}, nil
 }
+
+// userExists reports whether user@host exists, for adopt_existing, which
+// relies on CREATE USER IF NOT EXISTS and ALTER USER of MySQL 5.7.6.
+func userExists(ctx context.Context, db *sql.DB, serverVersion *version.Version, user string, host string) (bool, error) {
+	ver, _ := version.NewVersion("5.7.6")
+	if serverVersion.LessThan(ver) {
+		return false, fmt.Errorf("adopt_existing requires MySQL 5.7.6 or later")
+	}
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(1) FROM mysql.user WHERE user = ? AND host = ?", user, host).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// initialGrantStatements returns the GRANT statements for initial_privileges,
+// after checking that the server knows every privilege.
+func initialGrantStatements(ctx context.Context, d *schema.ResourceData, db *sql.DB) ([]string, error) {
+	blocks := d.Get("initial_privileges").([]interface{})
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+
+	supported, err := supportedPrivileges(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmts []string
+	for _, b := range blocks {
+		block := b.(map[string]interface{})
+		privileges := block["privileges"].(*schema.Set).List()
+		for _, privilege := range privileges {
+			if err := supported.check(privilege.(string)); err != nil {
+				return nil, fmt.Errorf("initial_privileges: %s on this server", err)
+			}
+		}
+
+		database := formatDatabaseName(block["database"].(string))
+		table := formatTableName(block["table"].(string))
+		if database == "*" && table != "*" {
+			return nil, fmt.Errorf("initial_privileges: table must be * when database is *, as MySQL has no *.%s scope", table)
+		}
+
+		stmts = append(stmts, fmt.Sprintf("GRANT %s ON %s.%s TO '%s'@'%s'",
+			flattenList(privileges, "%s"),
+			database,
+			table,
+			d.Get("user").(string),
+			normalizeHost(d.Get("host").(string))))
+	}
+	return stmts, nil
+}
+
+// grantInitialPrivileges runs the statements of initialGrantStatements. If
+// one fails a user that was just created is dropped again, so that it never
+// exists with only part of its privileges.
+func grantInitialPrivileges(ctx context.Context, d *schema.ResourceData, meta interface{}, db *sql.DB, stmts []string, created bool) error {
+	for _, stmtSQL := range stmts {
+		log.Println("Executing statement:", stmtSQL)
+		if _, err := execWithRetry(ctx, meta, db, stmtSQL); err != nil {
+			if !created {
+				return fmt.Errorf("error granting initial_privileges (%s): %s", stmtSQL, err)
+			}
+			dropSQL := fmt.Sprintf("DROP USER '%s'@'%s'", d.Get("user").(string), normalizeHost(d.Get("host").(string)))
+			log.Println("Executing statement:", dropSQL)
+			if _, dropErr := execWithRetry(ctx, meta, db, dropSQL); dropErr != nil {
+				return fmt.Errorf("error granting initial_privileges (%s): %s; dropping the user failed as well: %s", stmtSQL, err, dropErr)
+			}
+			return fmt.Errorf("error granting initial_privileges (%s): %s", stmtSQL, err)
+		}
+	}
+	return nil
+}
+
+// passwordHashClause returns the IDENTIFIED clause that sets an already hashed
+// password, for plugin or mysql_native_password if plugin is empty. A hash
+// starting with 0x is taken as a hex literal, for caching_sha2_password
+// hashes that contain bytes which can't be written in a string literal.
+func passwordHashClause(plugin string, hash string, serverVersion *version.Version) (string, error) {
+	if plugin == "" {
+		plugin = nativePasswordPlugin
+	}
+
+	// IDENTIFIED WITH ... AS came with MySQL 5.7.6, older servers only know
+	// native hashes.
+	ver, _ := version.NewVersion("5.7.6")
+	if serverVersion.LessThan(ver) {
+		if plugin != nativePasswordPlugin {
+			return "", fmt.Errorf("password_hash with auth_plugin %s requires MySQL 5.7.6 or later", plugin)
+		}
+		return " IDENTIFIED BY PASSWORD " + quoteString(hash), nil
+	}
+
+	return fmt.Sprintf(" IDENTIFIED WITH %s AS %s", quoteIdentifier(plugin), passwordHashLiteral(hash)), nil
+}
+
+func passwordHashLiteral(hash string) string {
+	if isHexLiteral(hash) {
+		return hash
+	}
+	return quoteString(hash)
+}
+
+func isHexLiteral(s string) bool {
+	if len(s) < 3 || !strings.EqualFold(s[:2], "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
+
+func updatePasswordHash(ctx context.Context, d *schema.ResourceData, meta interface{}, db *sql.DB, plugin string) error {
+	currentVersion, err := serverVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	user := fmt.Sprintf("'%s'@'%s'", d.Get("user").(string), normalizeHost(d.Get("host").(string)))
+	hash := d.Get("password_hash").(string)
+
+	var stmtSQL string
+	ver, _ := version.NewVersion("5.7.6")
+	if currentVersion.LessThan(ver) {
+		if plugin != "" && plugin != nativePasswordPlugin {
+			return fmt.Errorf("password_hash with auth_plugin %s requires MySQL 5.7.6 or later", plugin)
+		}
+		stmtSQL = fmt.Sprintf("SET PASSWORD FOR %s = %s", user, quoteString(hash))
+	} else {
+		clause, err := passwordHashClause(plugin, hash, currentVersion)
+		if err != nil {
+			return err
+		}
+		stmtSQL = "ALTER USER " + user + clause
+	}
+
+	log.Println("Executing statement:", stmtSQL)
+	_, err = execWithRetry(ctx, meta, db, stmtSQL)
+	return err
+}
+
+// readPasswordHash compares password_hash with the authentication_string of
+// the account, so that a password changed outside of Terraform shows a diff.
+// Nothing is read for users without a password hash in state.
+func readPasswordHash(ctx context.Context, d *schema.ResourceData, db *sql.DB) error {
+	hash := d.Get("password_hash").(string)
+	if hash == "" {
+		return nil
+	}
+
+	var authString, authHex string
+	err := db.QueryRowContext(ctx, "SELECT AUTHENTICATION_STRING, HEX(AUTHENTICATION_STRING) FROM mysql.user WHERE USER = ? AND HOST = ?",
+		d.Get("user").(string), normalizeHost(d.Get("host").(string))).Scan(&authString, &authHex)
+	if err != nil {
+		return err
+	}
+
+	if isHexLiteral(hash) {
+		if !strings.EqualFold(hash[2:], authHex) {
+			d.Set("password_hash", "0x"+authHex)
+		}
+		return nil
+	}
+	if hash != authString {
+		d.Set("password_hash", authString)
+	}
+	return nil
+}
+
+// tlsRequirement is the REQUIRE clause of an account. Type is NONE, SSL, X509
+// or SPECIFIED, the latter when any of the other fields is set.
+type tlsRequirement struct {
+	Type    string
+	Cipher  string
+	Issuer  string
+	Subject string
+}
+
+// String formats the requirement the way it is written after REQUIRE.
+func (r tlsRequirement) String() string {
+	if r.Type != "SPECIFIED" {
+		return r.Type
+	}
+
+	var parts []string
+	if r.Subject != "" {
+		parts = append(parts, "SUBJECT "+quoteString(r.Subject))
+	}
+	if r.Issuer != "" {
+		parts = append(parts, "ISSUER "+quoteString(r.Issuer))
+	}
+	if r.Cipher != "" {
+		parts = append(parts, "CIPHER "+quoteString(r.Cipher))
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// parseTLSOption parses a tls_option value, i.e. what follows REQUIRE.
+func parseTLSOption(option string) (tlsRequirement, error) {
+	tokens, err := tokenizeSQL(option)
+	if err != nil {
+		return tlsRequirement{}, err
+	}
+	r, n, err := parseRequire(tokens)
+	if err != nil {
+		return tlsRequirement{}, err
+	}
+	if n != len(tokens) {
+		return tlsRequirement{}, fmt.Errorf("unexpected %q after the TLS requirement", tokens[n].text)
+	}
+	return r, nil
+}
+
+// requireOfCreateUser finds the REQUIRE clause in the output of SHOW CREATE
+// USER. Accounts without one require nothing.
+func requireOfCreateUser(createSQL string) (tlsRequirement, error) {
+	tokens, err := tokenizeSQL(createSQL)
+	if err != nil {
+		return tlsRequirement{}, err
+	}
+	for i, t := range tokens {
+		if !t.quoted && strings.EqualFold(t.text, "REQUIRE") {
+			r, _, err := parseRequire(tokens[i+1:])
+			return r, err
+		}
+	}
+	return tlsRequirement{Type: "NONE"}, nil
+}
+
+// parseRequire parses a requirement from the start of tokens and returns it
+// with the number of tokens it took.
+func parseRequire(tokens []sqlToken) (tlsRequirement, int, error) {
+	if len(tokens) == 0 {
+		return tlsRequirement{}, 0, fmt.Errorf("missing TLS requirement")
+	}
+	if first := strings.ToUpper(tokens[0].text); !tokens[0].quoted && (first == "NONE" || first == "SSL" || first == "X509") {
+		return tlsRequirement{Type: first}, 1, nil
+	}
+
+	r := tlsRequirement{Type: "SPECIFIED"}
+	n := 0
+	for {
+		next := n
+		if n > 0 && n < len(tokens) && !tokens[n].quoted && strings.EqualFold(tokens[n].text, "AND") {
+			next++
+		}
+		if next+1 >= len(tokens) || tokens[next].quoted || !tokens[next+1].quoted {
+			break
+		}
+
+		var field *string
+		switch strings.ToUpper(tokens[next].text) {
+		case "CIPHER":
+			field = &r.Cipher
+		case "ISSUER":
+			field = &r.Issuer
+		case "SUBJECT":
+			field = &r.Subject
+		}
+		if field == nil {
+			break
+		}
+		*field = tokens[next+1].text
+		n = next + 2
+	}
+
+	if r.Cipher == "" && r.Issuer == "" && r.Subject == "" {
+		return tlsRequirement{}, 0, fmt.Errorf("unknown TLS requirement %q", tokens[0].text)
+	}
+	return r, n, nil
+}
+
+type sqlToken struct {
+	text string
+	// quoted is set for string literals and quoted identifiers, whose text
+	// is unescaped.
+	quoted bool
+}
+
+// tokenizeSQL splits a statement into words and quoted strings. It knows
+// just enough SQL to tell keywords from the contents of strings and
+// identifiers.
+func tokenizeSQL(s string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			var text strings.Builder
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '\\' && c != '`' && i+1 < len(s) {
+					i++
+					text.WriteByte(s[i])
+					continue
+				}
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						i++
+						text.WriteByte(c)
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				text.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated %c in %q", c, s)
+			}
+			tokens = append(tokens, sqlToken{text: text.String(), quoted: true})
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r'\"`", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: s[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+func validateTLSOption(v interface{}, k string) ([]string, []error) {
+	if _, err := parseTLSOption(v.(string)); err != nil && v.(string) != "" {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// suppressTLSOptionDiff compares tls_option by meaning, so that the order of
+// CIPHER, ISSUER and SUBJECT, AND between them, and the case of keywords
+// don't show a diff against what the server reports.
+func suppressTLSOptionDiff(k, old, new string, d *schema.ResourceData) bool {
+	o, err := parseTLSOption(old)
+	if err != nil {
+		return false
+	}
+	n, err := parseTLSOption(new)
+	if err != nil {
+		return false
+	}
+	return o == n
+}
+
+// readTLSRequirement reads the REQUIRE clause of the account back from SHOW
+// CREATE USER, which MySQL has since 5.7.6, so that requirements changed
+// outside of Terraform show a diff.
+func readTLSRequirement(ctx context.Context, d *schema.ResourceData, db *sql.DB) error {
+	currentVersion, err := serverVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	requiredVersion, _ := version.NewVersion("5.7.6")
+	if currentVersion.LessThan(requiredVersion) {
+		return nil
+	}
+
+	var createSQL string
+	stmtSQL := fmt.Sprintf("SHOW CREATE USER '%s'@'%s'", d.Get("user").(string), normalizeHost(d.Get("host").(string)))
+	log.Println("Executing query:", stmtSQL)
+	if err := db.QueryRowContext(ctx, stmtSQL).Scan(&createSQL); err != nil {
+		return err
+	}
+
+	r, err := requireOfCreateUser(createSQL)
+	if err != nil {
+		return fmt.Errorf("could not parse the TLS requirement of %s: %w", d.Id(), err)
+	}
+
+	// Keep the configured spelling if it means the same.
+	if current, err := parseTLSOption(d.Get("tls_option").(string)); err != nil || current != r {
+		d.Set("tls_option", r.String())
+	}
+	return d.Set("tls_requirement", []interface{}{map[string]interface{}{
+		"type":    r.Type,
+		"cipher":  r.Cipher,
+		"issuer":  r.Issuer,
+		"subject": r.Subject,
+	}})
+}
+
+// supportsUserAttributes reports whether the server knows CREATE/ALTER USER
+// ... COMMENT and ATTRIBUTE, added in MySQL 8.0.21.
+func supportsUserAttributes(ctx context.Context, db *sql.DB) (bool, error) {
+	versionString, err := serverVersionString(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	if strings.Contains(versionString, "MariaDB") {
+		return false, nil
+	}
+
+	currentVersion, err := serverVersion(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	requiredVersion, _ := version.NewVersion("8.0.21")
+	return !currentVersion.LessThan(requiredVersion), nil
+}
+
+func updateUserAttributes(ctx context.Context, d *schema.ResourceData, meta interface{}, db *sql.DB) error {
+	if !d.HasChange("comment") && !d.HasChange("attribute") {
+		return nil
+	}
+
+	supported, err := supportsUserAttributes(ctx, db)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		log.Printf("[WARN] comment and attribute of %s are ignored, they require MySQL 8.0.21 or later", d.Id())
+		return nil
+	}
+
+	user := fmt.Sprintf("'%s'@'%s'", d.Get("user").(string), normalizeHost(d.Get("host").(string)))
+
+	if d.HasChange("comment") {
+		stmtSQL := fmt.Sprintf("ALTER USER %s COMMENT %s", user, quoteString(d.Get("comment").(string)))
+		log.Println("Executing statement:", stmtSQL)
+		if _, err := execWithRetry(ctx, meta, db, stmtSQL); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("attribute") {
+		o, n := d.GetChange("attribute")
+		patch, err := userAttributePatch(o.(string), n.(string))
+		if err != nil {
+			return err
+		}
+
+		stmtSQL := fmt.Sprintf("ALTER USER %s ATTRIBUTE %s", user, quoteString(patch))
+		log.Println("Executing statement:", stmtSQL)
+		if _, err := execWithRetry(ctx, meta, db, stmtSQL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// userAttributePatch turns the old and new attribute documents into the patch
+// ALTER USER ... ATTRIBUTE expects: the server merges it into the stored
+// attributes, so keys that were removed have to be set to null.
+func userAttributePatch(oldJSON string, newJSON string) (string, error) {
+	oldAttrs := map[string]interface{}{}
+	if oldJSON != "" {
+		if err := json.Unmarshal([]byte(oldJSON), &oldAttrs); err != nil {
+			return "", fmt.Errorf("attribute: %s", err)
+		}
+	}
+	patch := map[string]interface{}{}
+	if newJSON != "" {
+		if err := json.Unmarshal([]byte(newJSON), &patch); err != nil {
+			return "", fmt.Errorf("attribute: %s", err)
+		}
+	}
+
+	for k := range oldAttrs {
+		if _, ok := patch[k]; !ok {
+			patch[k] = nil
+		}
+	}
+
+	b, err := json.Marshal(patch)
+	return string(b), err
+}
+
+func readUserAttributes(ctx context.Context, d *schema.ResourceData, db *sql.DB) error {
+	supported, err := supportsUserAttributes(ctx, db)
+	if err != nil || !supported {
+		return err
+	}
+
+	var attrJSON sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT ATTRIBUTE FROM information_schema.USER_ATTRIBUTES WHERE USER = ? AND HOST = ?",
+		d.Get("user").(string), normalizeHost(d.Get("host").(string))).Scan(&attrJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// COMMENT is stored as the "comment" key of the same document.
+	attrs := map[string]interface{}{}
+	if attrJSON.Valid && attrJSON.String != "" {
+		if err := json.Unmarshal([]byte(attrJSON.String), &attrs); err != nil {
+			return err
+		}
+	}
+
+	comment, _ := attrs["comment"].(string)
+	delete(attrs, "comment")
+	d.Set("comment", comment)
+
+	attribute := ""
+	if len(attrs) > 0 {
+		b, err := json.Marshal(attrs)
+		if err != nil {
+			return err
+		}
+		attribute = string(b)
+	}
+	d.Set("attribute", attribute)
+
+	return nil
+}
+
+// writeOnlyString returns the value of a write-only attribute. It is only
+// available from the configuration and never persisted to state.
+func writeOnlyString(d *schema.ResourceData, key string) string {
+	raw := d.GetRawConfig()
+	if raw.IsNull() || !raw.IsKnown() {
+		return ""
+	}
+	v := raw.GetAttr(key)
+	if v.IsNull() || !v.IsKnown() {
+		return ""
+	}
+	return v.AsString()
+}
+
+// normalizeHost returns the host part of an account the way it is compared:
+// trimmed, lower-cased, and with an empty host meaning any host.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return "%"
+	}
+	return host
+}
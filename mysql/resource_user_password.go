@@ -1,19 +1,35 @@
 package mysql
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/gofrs/uuid"
 	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/encryption"
-	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/keybase/go-crypto/openpgp/packet"
 )
 
+const accessDeniedErrCode = 1045
+
 func resourceUserPassword() *schema.Resource {
 	return &schema.Resource{
-		Create: SetUserPassword,
-		Read:   ReadUserPassword,
-		Delete: DeleteUserPassword,
+		CreateContext: SetUserPassword,
+		ReadContext:   ReadUserPassword,
+		UpdateContext: UpdateUserPassword,
+		DeleteContext: DeleteUserPassword,
+		CustomizeDiff: customizeUserPasswordDiff,
 		Schema: map[string]*schema.Schema{
 			"user": {
 				Type:     schema.TypeString,
@@ -39,38 +55,55 @@ func resourceUserPassword() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"verify_on_read": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"verification_password": {
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
 		},
 	}
 }
 
-func SetUserPassword(d *schema.ResourceData, meta interface{}) error {
-	db, err := connectToMySQL(meta.(*MySQLConfiguration))
+func SetUserPassword(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	db, err := connectToMySQL(ctx, meta.(*MySQLConfiguration))
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	uuid, err := uuid.NewV4()
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	password := uuid.String()
 	pgpKey := d.Get("pgp_key").(string)
-	encryptionKey, err := encryption.RetrieveGPGKey(pgpKey)
+	encryptionKey, err := retrievePGPKey(ctx, pgpKey)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
-	fingerprint, encrypted, err := encryption.EncryptValue(encryptionKey, password, "MySQL Password")
+	fingerprint, encrypted, err := encryptPassword(encryptionKey, password)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	d.Set("key_fingerprint", fingerprint)
 	d.Set("encrypted_password", encrypted)
+	if d.Get("verify_on_read").(bool) {
+		d.Set("verification_password", password)
+	}
 
 	requiredVersion, _ := version.NewVersion("8.0.0")
-	currentVersion, err := serverVersion(db)
+	currentVersion, err := serverVersion(ctx, db)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 
 	passSQL := fmt.Sprintf("'%s'", password)
@@ -83,9 +116,9 @@ func SetUserPassword(d *schema.ResourceData, meta interface{}) error {
 		d.Get("host").(string),
 		passSQL)
 
-	_, err = db.Exec(sql)
+	_, err = execWithRetry(ctx, meta, db, sql)
 	if err != nil {
-		return err
+		return diag.FromErr(err)
 	}
 	user := fmt.Sprintf("%s@%s",
 		d.Get("user").(string),
@@ -94,13 +127,162 @@ func SetUserPassword(d *schema.ResourceData, meta interface{}) error {
 	return nil
 }
 
-func ReadUserPassword(d *schema.ResourceData, meta interface{}) error {
-	// This is obviously not possible.
+func ReadUserPassword(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	// Reading the password back is obviously not possible, but when asked
+	// to we can at least check that it still works.
+	if !d.Get("verify_on_read").(bool) {
+		return nil
+	}
+
+	password := d.Get("verification_password").(string)
+	if password == "" {
+		log.Printf("[WARN] verify_on_read is set for %s but no password was recorded at creation; skipping verification", d.Id())
+		return nil
+	}
+
+	db, err := meta.(*MySQLConfiguration).connectAs(ctx, d.Get("user").(string), password)
+	if err != nil {
+		// Kept in state: access is also denied when the provider connects
+		// from somewhere the account's host doesn't match, and dropping the
+		// resource would then rotate the password on every apply.
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == accessDeniedErrCode {
+			return diag.Diagnostics{{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("password for %s no longer authenticates", d.Id()),
+				Detail: "The server denied access with the generated password. It may have been changed outside of Terraform, " +
+					"or the provider connects from a host the account's host doesn't match. Taint the resource to generate a new password.",
+			}}
+		}
+		return diag.Errorf("error verifying password for %s: %s", d.Id(), err)
+	}
+	db.Close()
+
 	return nil
 }
 
-func DeleteUserPassword(d *schema.ResourceData, meta interface{}) error {
+func UpdateUserPassword(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
+	// Only turning verify_on_read off changes in place, see
+	// customizeUserPasswordDiff.
+	if !d.Get("verify_on_read").(bool) {
+		d.Set("verification_password", "")
+	}
+
+	return ReadUserPassword(ctx, d, meta)
+}
+
+// customizeUserPasswordDiff replaces the resource when verify_on_read is
+// turned on, as the password it verifies is only known when it is generated.
+func customizeUserPasswordDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() != "" && d.HasChange("verify_on_read") && d.Get("verify_on_read").(bool) {
+		return d.ForceNew("verify_on_read")
+	}
+	return nil
+}
+
+func DeleteUserPassword(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if diags := checkWritable(meta); diags != nil {
+		return diags
+	}
+
 	// We don't need to do anything on the MySQL side here. Just need TF
 	// to remove from the state file.
 	return nil
 }
+
+const keybasePrefix = "keybase:"
+
+// retrievePGPKey returns the base64-encoded PGP public key pgp_key stands
+// for: pgp_key itself, or the primary key of the Keybase user given as
+// keybase:<name>.
+func retrievePGPKey(ctx context.Context, pgpKey string) (string, error) {
+	if !strings.HasPrefix(pgpKey, keybasePrefix) {
+		return pgpKey, nil
+	}
+
+	key, err := fetchKeybaseKey(ctx, strings.TrimPrefix(pgpKey, keybasePrefix))
+	if err != nil {
+		return "", fmt.Errorf("Error retrieving Public Key for %s: %s", pgpKey, err)
+	}
+	return key, nil
+}
+
+func fetchKeybaseKey(ctx context.Context, username string) (string, error) {
+	lookup := "https://keybase.io/_/api/1.0/user/lookup.json?fields=public_keys&usernames=" + url.QueryEscape(username)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookup, nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Status struct {
+			Name string `json:"name"`
+		} `json:"status"`
+		Them []struct {
+			PublicKeys struct {
+				Primary struct {
+					Bundle string `json:"bundle"`
+				} `json:"primary"`
+			} `json:"public_keys"`
+		} `json:"them"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Status.Name != "OK" {
+		return "", fmt.Errorf("got non-OK response: %q", out.Status.Name)
+	}
+	if len(out.Them) != 1 || out.Them[0].PublicKeys.Primary.Bundle == "" {
+		return "", fmt.Errorf("unable to fetch the key of %q from keybase", username)
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(out.Them[0].PublicKeys.Primary.Bundle))
+	if err != nil {
+		return "", err
+	}
+	if len(entities) != 1 {
+		return "", fmt.Errorf("primary key could not be parsed for user %q", username)
+	}
+
+	var serialized bytes.Buffer
+	if err := entities[0].Serialize(&serialized); err != nil {
+		return "", fmt.Errorf("error serializing the key of %q: %s", username, err)
+	}
+	return base64.StdEncoding.EncodeToString(serialized.Bytes()), nil
+}
+
+// encryptPassword encrypts password for the base64-encoded PGP public key and
+// returns the key's fingerprint along with the base64-encoded message.
+func encryptPassword(pgpKey string, password string) (string, string, error) {
+	data, err := base64.StdEncoding.DecodeString(pgpKey)
+	if err != nil {
+		return "", "", fmt.Errorf("Error encrypting MySQL Password: error decoding given PGP key: %s", err)
+	}
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return "", "", fmt.Errorf("Error encrypting MySQL Password: error parsing given PGP key: %s", err)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := openpgp.Encrypt(&encrypted, []*openpgp.Entity{entity}, nil, nil, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("Error encrypting MySQL Password: %s", err)
+	}
+	if _, err := w.Write([]byte(password)); err != nil {
+		return "", "", fmt.Errorf("Error encrypting MySQL Password: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", "", fmt.Errorf("Error encrypting MySQL Password: %s", err)
+	}
+
+	return fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint), base64.StdEncoding.EncodeToString(encrypted.Bytes()), nil
+}
@@ -1,8 +1,14 @@
 package mysql
 
 import (
-	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/keybase/go-crypto/openpgp"
 )
 
 func TestAccUserPassword_basic(t *testing.T) {
@@ -23,6 +29,73 @@ func TestAccUserPassword_basic(t *testing.T) {
 	})
 }
 
+func TestAccUserPassword_verifyOnRead(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserPasswordConfig_verifyOnRead,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user_password.test", "verify_on_read", "true"),
+					resource.TestCheckResourceAttrSet("mysql_user_password.test", "verification_password"),
+				),
+			},
+		},
+	})
+}
+
+func TestEncryptPassword(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range entity.Identities {
+		if err := id.SelfSignature.SignUserId(id.UserId.Id, entity.PrimaryKey, entity.PrivateKey, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		if err := subkey.Sig.SignKey(subkey.PublicKey, entity.PrivateKey, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var key bytes.Buffer
+	if err := entity.Serialize(&key); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint, encrypted, err := encryptPassword(base64.StdEncoding.EncodeToString(key.Bytes()), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint != fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint) {
+		t.Fatalf("unexpected fingerprint %s", fingerprint)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := openpgp.ReadMessage(bytes.NewReader(data), openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "secret" {
+		t.Fatalf("got %q, want %q", got, "secret")
+	}
+
+	if _, _, err := encryptPassword("not a key", "secret"); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
+
 const testAccUserPasswordConfig_basic = `
 resource "mysql_user" "test" {
   user = "jdoe"
@@ -33,3 +106,17 @@ resource "mysql_user_password" "test" {
   pgp_key = "keybase:joestump"
 }
 `
+
+const testAccUserPasswordConfig_verifyOnRead = `
+resource "mysql_user" "test" {
+  user = "jdoe"
+  host = "%"
+}
+
+resource "mysql_user_password" "test" {
+  user           = "${mysql_user.test.user}"
+  host           = "${mysql_user.test.host}"
+  pgp_key        = "keybase:joestump"
+  verify_on_read = true
+}
+`
@@ -1,13 +1,15 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"testing"
 
-	"github.com/hashicorp/terraform-plugin-sdk/helper/resource"
-	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
 )
 
 func TestAccUser_basic(t *testing.T) {
@@ -50,6 +52,61 @@ func TestAccUser_basic(t *testing.T) {
 	})
 }
 
+func TestAccUser_tlsRequirement(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserConfig_cipher,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_requirement.0.type", "SPECIFIED"),
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_requirement.0.cipher", "ECDHE-RSA-AES256-GCM-SHA384"),
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_requirement.0.issuer", "/CN=Test CA"),
+				),
+			},
+			{
+				Config: testAccUserConfig_basic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_option", "NONE"),
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_requirement.0.type", "NONE"),
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_requirement.0.cipher", ""),
+				),
+			},
+		},
+	})
+}
+
+func TestAccUser_rename(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserConfig_basic,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "user", "jdoe"),
+					resource.TestCheckResourceAttr("mysql_user.test", "host", "example.com"),
+				),
+			},
+			{
+				Config: testAccUserConfig_renamed,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "id", "jdoe2@example.org"),
+					resource.TestCheckResourceAttr("mysql_user.test", "user", "jdoe2"),
+					resource.TestCheckResourceAttr("mysql_user.test", "host", "example.org"),
+					resource.TestCheckResourceAttr("mysql_user.test", "plaintext_password", hashSum("password")),
+				),
+			},
+		},
+	})
+}
+
 func TestAccUser_auth(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
@@ -97,6 +154,235 @@ func TestAccUser_deprecated(t *testing.T) {
 	})
 }
 
+func TestAccUser_passwordHash(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccUserConfig_passwordHash, "*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "password_hash", "*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccUserConfig_passwordHash, "*DACDE7F5744D3CB439B40D938673B8240B824853"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "password_hash", "*DACDE7F5744D3CB439B40D938673B8240B824853"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccUser_attributes(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				t.Fatal(err)
+			}
+			supported, err := supportsUserAttributes(context.Background(), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !supported {
+				t.Skip("User attributes require MySQL 8.0.21+")
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserConfig_attributes,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "comment", "service account"),
+					resource.TestCheckResourceAttr("mysql_user.test", "attribute", `{"env":"prod","team":"db"}`),
+				),
+			},
+			{
+				Config: testAccUserConfig_attributesChanged,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "comment", "batch account"),
+					resource.TestCheckResourceAttr("mysql_user.test", "attribute", `{"team":"etl"}`),
+				),
+			},
+		},
+	})
+}
+
+func TestAccUser_initialPrivileges(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserConfig_initialPrivileges,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					testAccUserHasPrivileges("jdoe", "example.com", "app", "*", "SELECT", "INSERT"),
+					testAccUserHasPrivileges("jdoe", "example.com", "app", "events", "DELETE"),
+					resource.TestCheckResourceAttr("mysql_user.test", "initial_privileges.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccUser_adoptExisting(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck: func() {
+			testAccPreCheck(t)
+			db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec("CREATE USER 'jdoe'@'example.com' IDENTIFIED BY 'old-password'"); err != nil {
+				t.Fatal(err)
+			}
+		},
+		Providers:    testAccProviders,
+		CheckDestroy: testAccUserCheckDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccUserConfig_adoptExisting,
+				Check: resource.ComposeTestCheckFunc(
+					testAccUserExists("mysql_user.test"),
+					resource.TestCheckResourceAttr("mysql_user.test", "adopt_existing", "true"),
+					resource.TestCheckResourceAttr("mysql_user.test", "tls_option", "SSL"),
+				),
+			},
+		},
+	})
+}
+
+func TestUserAttributePatch(t *testing.T) {
+	cases := []struct {
+		old  string
+		new  string
+		want string
+	}{
+		{"", `{"team":"db"}`, `{"team":"db"}`},
+		{`{"env":"prod","team":"db"}`, `{"team":"etl"}`, `{"env":null,"team":"etl"}`},
+		{`{"team":"db"}`, "", `{"team":null}`},
+	}
+
+	for _, c := range cases {
+		got, err := userAttributePatch(c.old, c.new)
+		if err != nil {
+			t.Fatalf("%s -> %s: unexpected error: %s", c.old, c.new, err)
+		}
+		if got != c.want {
+			t.Errorf("%s -> %s: got %s, want %s", c.old, c.new, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	cases := map[string]string{
+		"":              "%",
+		"  ":            "%",
+		"%":             "%",
+		" Example.COM ": "example.com",
+		"10.0.0.%":      "10.0.0.%",
+	}
+
+	for in, want := range cases {
+		if got := normalizeHost(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPasswordHashClause(t *testing.T) {
+	v5, _ := version.NewVersion("5.6.40")
+	v8, _ := version.NewVersion("8.0.32")
+	cases := []struct {
+		plugin  string
+		hash    string
+		version *version.Version
+		want    string
+		wantErr bool
+	}{
+		{"", "*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19", v8, " IDENTIFIED WITH `mysql_native_password` AS '*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19'", false},
+		{"", "*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19", v5, " IDENTIFIED BY PASSWORD '*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19'", false},
+		{"caching_sha2_password", "0x244124303035", v8, " IDENTIFIED WITH `caching_sha2_password` AS 0x244124303035", false},
+		{"caching_sha2_password", "0xnothex", v8, " IDENTIFIED WITH `caching_sha2_password` AS '0xnothex'", false},
+		{"caching_sha2_password", "0x244124303035", v5, "", true},
+	}
+
+	for _, c := range cases {
+		got, err := passwordHashClause(c.plugin, c.hash, c.version)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("%s %s on %s: got %q (%v), want %q", c.plugin, c.hash, c.version, got, err, c.want)
+		}
+	}
+}
+
+func TestParseTLSOption(t *testing.T) {
+	cases := []struct {
+		option string
+		want   tlsRequirement
+	}{
+		{"NONE", tlsRequirement{Type: "NONE"}},
+		{"ssl", tlsRequirement{Type: "SSL"}},
+		{"X509", tlsRequirement{Type: "X509"}},
+		{"CIPHER 'x' AND ISSUER 'y'", tlsRequirement{Type: "SPECIFIED", Cipher: "x", Issuer: "y"}},
+		{"issuer '/CN=it''s' subject \"/CN=me\"", tlsRequirement{Type: "SPECIFIED", Issuer: "/CN=it's", Subject: "/CN=me"}},
+	}
+	for _, c := range cases {
+		got, err := parseTLSOption(c.option)
+		if err != nil || got != c.want {
+			t.Errorf("%q: got %+v (%v), want %+v", c.option, got, err, c.want)
+		}
+		if again, err := parseTLSOption(got.String()); err != nil || again != got {
+			t.Errorf("%q: %q does not parse back: %+v (%v)", c.option, got.String(), again, err)
+		}
+	}
+
+	for _, option := range []string{"", "TLS", "CIPHER", "CIPHER x", "CIPHER 'x' AND", "SSL AND X509", "CIPHER 'x"} {
+		if _, err := parseTLSOption(option); err == nil {
+			t.Errorf("%q: expected an error", option)
+		}
+	}
+
+	if !suppressTLSOptionDiff("", "ISSUER '/CN=ca' AND CIPHER 'x'", "cipher 'x' issuer '/CN=ca'", nil) {
+		t.Error("expected the order of the requirements to be ignored")
+	}
+	if suppressTLSOptionDiff("", "CIPHER 'x'", "CIPHER 'y'", nil) {
+		t.Error("expected a different cipher to show a diff")
+	}
+}
+
+func TestRequireOfCreateUser(t *testing.T) {
+	cases := []struct {
+		createSQL string
+		want      tlsRequirement
+	}{
+		{"CREATE USER `jdoe`@`example.com` IDENTIFIED WITH 'caching_sha2_password' AS '$A$005$x\\'REQUIRE SSL' REQUIRE NONE PASSWORD EXPIRE DEFAULT ACCOUNT UNLOCK",
+			tlsRequirement{Type: "NONE"}},
+		{"CREATE USER 'require'@'%' REQUIRE SSL PASSWORD EXPIRE DEFAULT",
+			tlsRequirement{Type: "SSL"}},
+		{"CREATE USER `jdoe`@`%` REQUIRE SUBJECT '/CN=jdoe' AND ISSUER '/CN=Test CA' AND CIPHER 'ECDHE-RSA-AES256-GCM-SHA384' PASSWORD EXPIRE DEFAULT",
+			tlsRequirement{Type: "SPECIFIED", Cipher: "ECDHE-RSA-AES256-GCM-SHA384", Issuer: "/CN=Test CA", Subject: "/CN=jdoe"}},
+		{"CREATE USER `jdoe`@`%` IDENTIFIED BY PASSWORD '*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19'",
+			tlsRequirement{Type: "NONE"}},
+	}
+	for _, c := range cases {
+		got, err := requireOfCreateUser(c.createSQL)
+		if err != nil || got != c.want {
+			t.Errorf("%s: got %+v (%v), want %+v", c.createSQL, got, err, c.want)
+		}
+	}
+}
+
 func testAccUserExists(rn string) resource.TestCheckFunc {
 	return func(s *terraform.State) error {
 		rs, ok := s.RootModule().Resources[rn]
@@ -108,7 +394,7 @@ func testAccUserExists(rn string) resource.TestCheckFunc {
 			return fmt.Errorf("user id not set")
 		}
 
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -139,7 +425,7 @@ func testAccUserAuthExists(rn string) resource.TestCheckFunc {
 			return fmt.Errorf("user id not set")
 		}
 
-		db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 		if err != nil {
 			return err
 		}
@@ -159,8 +445,44 @@ func testAccUserAuthExists(rn string) resource.TestCheckFunc {
 	}
 }
 
+// testAccUserHasPrivileges checks that user@host holds privileges on
+// database.table.
+func testAccUserHasPrivileges(user, host, database, table string, privileges ...string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
+		if err != nil {
+			return err
+		}
+
+		rows, err := db.Query(fmt.Sprintf("SHOW GRANTS FOR %s@%s", quoteString(user), quoteString(host)))
+		if err != nil {
+			return fmt.Errorf("error reading grants: %s", err)
+		}
+		defer rows.Close()
+
+		held := map[string]bool{}
+		for rows.Next() {
+			var grant string
+			if err := rows.Scan(&grant); err != nil {
+				return err
+			}
+			if isGrantOn(grant, grantObjectTable, database, table) {
+				for _, p := range grantedPrivileges(grant) {
+					held[p] = true
+				}
+			}
+		}
+		for _, p := range privileges {
+			if !held[p] {
+				return fmt.Errorf("%s@%s doesn't hold %s on %s.%s", user, host, p, database, table)
+			}
+		}
+		return rows.Err()
+	}
+}
+
 func testAccUserCheckDestroy(s *terraform.State) error {
-	db, err := connectToMySQL(testAccProvider.Meta().(*MySQLConfiguration))
+	db, err := connectToMySQL(context.Background(), testAccProvider.Meta().(*MySQLConfiguration))
 	if err != nil {
 		return err
 	}
@@ -201,6 +523,15 @@ resource "mysql_user" "test" {
 }
 `
 
+const testAccUserConfig_cipher = `
+resource "mysql_user" "test" {
+    user = "jdoe"
+    host = "example.com"
+    plaintext_password = "password"
+    tls_option = "CIPHER 'ECDHE-RSA-AES256-GCM-SHA384' AND ISSUER '/CN=Test CA'"
+}
+`
+
 const testAccUserConfig_newPass = `
 resource "mysql_user" "test" {
     user = "jdoe"
@@ -209,6 +540,14 @@ resource "mysql_user" "test" {
 }
 `
 
+const testAccUserConfig_renamed = `
+resource "mysql_user" "test" {
+    user = "jdoe2"
+    host = "example.org"
+    plaintext_password = "password"
+}
+`
+
 const testAccUserConfig_deprecated = `
 resource "mysql_user" "test" {
     user = "jdoe"
@@ -232,3 +571,60 @@ resource "mysql_user" "test" {
     auth_plugin = "mysql_no_login"
 }
 `
+
+const testAccUserConfig_attributes = `
+resource "mysql_user" "test" {
+    user               = "jdoe"
+    host               = "example.com"
+    plaintext_password = "password"
+    comment            = "service account"
+    attribute          = jsonencode({ team = "db", env = "prod" })
+}
+`
+
+const testAccUserConfig_attributesChanged = `
+resource "mysql_user" "test" {
+    user               = "jdoe"
+    host               = "example.com"
+    plaintext_password = "password"
+    comment            = "batch account"
+    attribute          = jsonencode({ team = "etl" })
+}
+`
+
+const testAccUserConfig_passwordHash = `
+resource "mysql_user" "test" {
+    user          = "jdoe"
+    host          = "example.com"
+    password_hash = "%s"
+}
+`
+
+const testAccUserConfig_initialPrivileges = `
+resource "mysql_user" "test" {
+    user               = "jdoe"
+    host               = "example.com"
+    plaintext_password = "password"
+
+    initial_privileges {
+        database   = "app"
+        privileges = ["SELECT", "INSERT"]
+    }
+
+    initial_privileges {
+        database   = "app"
+        table      = "events"
+        privileges = ["DELETE"]
+    }
+}
+`
+
+const testAccUserConfig_adoptExisting = `
+resource "mysql_user" "test" {
+    user               = "jdoe"
+    host               = "example.com"
+    plaintext_password = "password"
+    tls_option         = "SSL"
+    adopt_existing     = true
+}
+`